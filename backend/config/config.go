@@ -1,7 +1,10 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -10,58 +13,415 @@ import (
 	"webcrawler/models"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/net/http/httpguts"
+	"gopkg.in/yaml.v3"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// Config is the effective server configuration. Settings are layered:
+// defaults, then the YAML config file, then environment variables, then
+// command-line flags.
 type Config struct {
-	DB               *gorm.DB
-	Port             string
-	JWTSecret        string
-	AllowedOrigins   []string
-	MaxConcurrentCrawls int
-	CrawlTimeout     int
-	MaxDepth         int
-	MaxPagesPerDomain int
+	DB                  *gorm.DB `json:"-" yaml:"-"`
+	ConfigFile          string   `json:"configFile,omitempty" yaml:"-"`
+	Port                string   `json:"port" yaml:"port"`
+	JWTSecret           string   `json:"jwtSecret" yaml:"jwt_secret"`
+	CredentialsKey      string   `json:"credentialsKey" yaml:"credentials_key"`
+	AllowedOrigins      []string `json:"allowedOrigins" yaml:"allowed_origins"`
+	DBHost              string   `json:"dbHost" yaml:"db_host"`
+	DBPort              string   `json:"dbPort" yaml:"db_port"`
+	DBUser              string   `json:"dbUser" yaml:"db_user"`
+	DBPassword          string   `json:"dbPassword" yaml:"db_password"`
+	DBName              string   `json:"dbName" yaml:"db_name"`
+	MaxConcurrentCrawls int      `json:"maxConcurrentCrawls" yaml:"max_concurrent_crawls"`
+	CrawlTimeout        int      `json:"crawlTimeout" yaml:"crawl_timeout"`
+	MaxDepth            int      `json:"maxDepth" yaml:"max_depth"`
+	MaxPagesPerDomain   int      `json:"maxPagesPerDomain" yaml:"max_pages_per_domain"`
+	UserAgent           string   `json:"userAgent" yaml:"user_agent"`
+	// DefaultHeaders are "Name: Value" headers sent with every crawl request
+	DefaultHeaders []string `json:"defaultHeaders" yaml:"default_headers"`
+	// ProxyURL is an http, https or socks5 proxy for all crawl requests; when
+	// empty the HTTP_PROXY and HTTPS_PROXY environment variables apply
+	ProxyURL string `json:"proxyUrl" yaml:"proxy_url"`
+	// MaxBodySize is the largest page body in bytes the crawler reads
+	MaxBodySize int64 `json:"maxBodySize" yaml:"max_body_size"`
+	// HostRequestsPerSecond limits the requests to one host across all
+	// crawls; 0 disables the limit
+	HostRequestsPerSecond float64 `json:"hostRequestsPerSecond" yaml:"host_requests_per_second"`
+	HostMaxConnections    int     `json:"hostMaxConnections" yaml:"host_max_connections"`
+	// AutoThrottle slows down requests to hosts that respond slowly or with
+	// errors, up to AutoThrottleMaxDelay seconds between requests
+	AutoThrottle         bool `json:"autoThrottle" yaml:"auto_throttle"`
+	AutoThrottleMaxDelay int  `json:"autoThrottleMaxDelay" yaml:"auto_throttle_max_delay"`
+	// Retries of transient failures; the delays are in milliseconds
+	RetryMaxAttempts int      `json:"retryMaxAttempts" yaml:"retry_max_attempts"`
+	RetryBaseDelay   int      `json:"retryBaseDelay" yaml:"retry_base_delay"`
+	RetryMaxDelay    int      `json:"retryMaxDelay" yaml:"retry_max_delay"`
+	RetryOn          []string `json:"retryOn" yaml:"retry_on"`
+	// Connection settings of the shared crawler transport; the timeouts are
+	// in seconds and a DNS cache TTL of 0 disables the cache
+	MaxIdleConns          int    `json:"maxIdleConns" yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int    `json:"maxIdleConnsPerHost" yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int    `json:"maxConnsPerHost" yaml:"max_conns_per_host"`
+	IdleConnTimeout       int    `json:"idleConnTimeout" yaml:"idle_conn_timeout"`
+	DialTimeout           int    `json:"dialTimeout" yaml:"dial_timeout"`
+	TLSHandshakeTimeout   int    `json:"tlsHandshakeTimeout" yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout int    `json:"responseHeaderTimeout" yaml:"response_header_timeout"`
+	DNSCacheTTL           int    `json:"dnsCacheTtl" yaml:"dns_cache_ttl"`
+	OrphanedCrawlPolicy   string `json:"orphanedCrawlPolicy" yaml:"orphaned_crawl_policy"`
+	CrawlLeaseTimeout     int    `json:"crawlLeaseTimeout" yaml:"crawl_lease_timeout"`
+	ShutdownGracePeriod   int    `json:"shutdownGracePeriod" yaml:"shutdown_grace_period"`
+
+	// args are the command-line arguments, kept to rebuild the config on reload
+	args []string
+}
+
+const (
+	// defaultJWTSecret is only meant for local development
+	defaultJWTSecret = "default-secret-change-in-production"
+	// defaultConfigFile is read when it exists and no other file is given
+	defaultConfigFile = "config.yaml"
+	// maxConcurrentCrawls caps the worker pool size
+	maxConcurrentCrawls = 100
+	// maxHostRequestsPerSecond and maxHostConnections cap the per-host limits
+	maxHostRequestsPerSecond = 100
+	maxHostConnections       = 50
+	// minBodySize is the smallest allowed page body limit
+	minBodySize = 1024
+	// maxAutoThrottleDelay caps the adaptive delay in seconds
+	maxAutoThrottleDelay = 300
+	// minCredentialsKeyLength is the shortest accepted credentials key
+	minCredentialsKeyLength = 16
+	// redactedValue replaces secrets in the config shown by the API
+	redactedValue = "[redacted]"
+)
+
+// setting ties a config field to its environment variable and command-line
+// flag. Settings without a flag, such as secrets, can't be passed on the
+// command line where they would show up in process listings.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	// value is a *string, *int, *int64, *float64, *bool or *[]string
+	value interface{}
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"PORT", "port", "HTTP port to listen on", &c.Port},
+		{"JWT_SECRET", "", "", &c.JWTSecret},
+		{"CREDENTIALS_KEY", "", "", &c.CredentialsKey},
+		{"ALLOWED_ORIGINS", "allowed-origins", "comma-separated CORS origins", &c.AllowedOrigins},
+		{"DB_HOST", "db-host", "MySQL host", &c.DBHost},
+		{"DB_PORT", "db-port", "MySQL port", &c.DBPort},
+		{"DB_USER", "db-user", "MySQL user", &c.DBUser},
+		{"DB_PASSWORD", "", "", &c.DBPassword},
+		{"DB_NAME", "db-name", "MySQL database", &c.DBName},
+		{"MAX_CONCURRENT_CRAWLS", "max-concurrent-crawls", "number of crawls run at once", &c.MaxConcurrentCrawls},
+		{"CRAWL_TIMEOUT", "crawl-timeout", "request timeout in seconds", &c.CrawlTimeout},
+		{"MAX_DEPTH", "max-depth", "default link depth of a crawl", &c.MaxDepth},
+		{"MAX_PAGES_PER_DOMAIN", "max-pages-per-domain", "default page budget per host", &c.MaxPagesPerDomain},
+		{"USER_AGENT", "user-agent", "default User-Agent of the crawler", &c.UserAgent},
+		{"DEFAULT_HEADERS", "default-headers", "comma-separated \"Name: Value\" headers sent with every crawl request", &c.DefaultHeaders},
+		{"PROXY_URL", "", "", &c.ProxyURL},
+		{"MAX_BODY_SIZE", "max-body-size", "largest page body read, in bytes", &c.MaxBodySize},
+		{"HOST_REQUESTS_PER_SECOND", "host-requests-per-second", "requests per second to one host, 0 for no limit", &c.HostRequestsPerSecond},
+		{"HOST_MAX_CONNECTIONS", "host-max-connections", "concurrent requests to one host", &c.HostMaxConnections},
+		{"AUTO_THROTTLE", "auto-throttle", "slow down for hosts that respond slowly or with errors", &c.AutoThrottle},
+		{"AUTO_THROTTLE_MAX_DELAY", "auto-throttle-max-delay", "longest adaptive delay between requests to a host, in seconds", &c.AutoThrottleMaxDelay},
+		{"RETRY_MAX_ATTEMPTS", "retry-max-attempts", "times a request is tried, 1 for no retries", &c.RetryMaxAttempts},
+		{"RETRY_BASE_DELAY", "retry-base-delay", "backoff before the first retry in milliseconds, doubled per retry", &c.RetryBaseDelay},
+		{"RETRY_MAX_DELAY", "retry-max-delay", "longest backoff between retries in milliseconds", &c.RetryMaxDelay},
+		{"RETRY_ON", "retry-on", "comma-separated failure classes to retry", &c.RetryOn},
+		{"MAX_IDLE_CONNS", "max-idle-conns", "idle connections kept open across all hosts", &c.MaxIdleConns},
+		{"MAX_IDLE_CONNS_PER_HOST", "max-idle-conns-per-host", "idle connections kept open per host", &c.MaxIdleConnsPerHost},
+		{"MAX_CONNS_PER_HOST", "max-conns-per-host", "open connections per host, 0 for no limit", &c.MaxConnsPerHost},
+		{"IDLE_CONN_TIMEOUT", "idle-conn-timeout", "seconds an idle connection is kept open", &c.IdleConnTimeout},
+		{"DIAL_TIMEOUT", "dial-timeout", "seconds to wait for a connection", &c.DialTimeout},
+		{"TLS_HANDSHAKE_TIMEOUT", "tls-handshake-timeout", "seconds to wait for a TLS handshake", &c.TLSHandshakeTimeout},
+		{"RESPONSE_HEADER_TIMEOUT", "response-header-timeout", "seconds to wait for response headers, 0 for no limit", &c.ResponseHeaderTimeout},
+		{"DNS_CACHE_TTL", "dns-cache-ttl", "seconds DNS lookups are cached, 0 to disable", &c.DNSCacheTTL},
+		{"ORPHANED_CRAWL_POLICY", "orphaned-crawl-policy", "requeue or error crawls whose worker died", &c.OrphanedCrawlPolicy},
+		{"CRAWL_LEASE_TIMEOUT", "crawl-lease-timeout", "seconds without a heartbeat before a crawl is recovered", &c.CrawlLeaseTimeout},
+		{"SHUTDOWN_GRACE_PERIOD", "shutdown-grace-period", "seconds running crawls get to finish on shutdown", &c.ShutdownGracePeriod},
+	}
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:                  "8080",
+		JWTSecret:             defaultJWTSecret,
+		AllowedOrigins:        []string{"http://localhost:5173", "http://localhost:3000"},
+		DBHost:                "localhost",
+		DBPort:                "3306",
+		DBUser:                "root",
+		DBPassword:            "password",
+		DBName:                "webcrawler",
+		MaxConcurrentCrawls:   5,
+		CrawlTimeout:          30,
+		MaxDepth:              3,
+		MaxPagesPerDomain:     100,
+		UserAgent:             "WebCrawler/1.0",
+		MaxBodySize:           10 * 1024 * 1024,
+		HostRequestsPerSecond: 2,
+		HostMaxConnections:    2,
+		AutoThrottle:          true,
+		AutoThrottleMaxDelay:  30,
+		RetryMaxAttempts:      3,
+		RetryBaseDelay:        500,
+		RetryMaxDelay:         10000,
+		RetryOn:               append([]string{}, models.RetryClasses...),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		MaxConnsPerHost:       10,
+		IdleConnTimeout:       90,
+		DialTimeout:           10,
+		TLSHandshakeTimeout:   10,
+		ResponseHeaderTimeout: 30,
+		DNSCacheTTL:           60,
+		OrphanedCrawlPolicy:   "requeue",
+		CrawlLeaseTimeout:     120,
+		ShutdownGracePeriod:   30,
+	}
 }
 
-func LoadConfig() *Config {
+// LoadConfig builds the configuration from the config file, the environment
+// and the command-line arguments, validates it and connects to the database
+func LoadConfig(args []string) (*Config, error) {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
 
-	config := &Config{
-		Port:      getEnv("PORT", "8080"),
-		JWTSecret: getEnv("JWT_SECRET", "default-secret-change-in-production"),
+	config, err := load(args)
+	if err != nil {
+		return nil, err
+	}
+	if config.JWTSecret == defaultJWTSecret {
+		log.Println("WARNING: JWT_SECRET is not set, using the insecure development secret")
+	}
+	if config.CredentialsKey == "" {
+		log.Println("WARNING: CREDENTIALS_KEY is not set, crawls with auth options are rejected")
 	}
-
-	// Parse allowed origins
-	origins := getEnv("ALLOWED_ORIGINS", "http://localhost:5173,http://localhost:3000")
-	config.AllowedOrigins = strings.Split(origins, ",")
-
-	// Parse crawler configuration
-	config.MaxConcurrentCrawls = getEnvAsInt("MAX_CONCURRENT_CRAWLS", 5)
-	config.CrawlTimeout = getEnvAsInt("CRAWL_TIMEOUT", 30)
-	config.MaxDepth = getEnvAsInt("MAX_DEPTH", 3)
-	config.MaxPagesPerDomain = getEnvAsInt("MAX_PAGES_PER_DOMAIN", 100)
 
 	// Initialize database
 	config.initDB()
 
-	return config
+	return config, nil
 }
 
-func (c *Config) initDB() {
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbPort := getEnv("DB_PORT", "3306")
-	dbUser := getEnv("DB_USER", "root")
-	dbPassword := getEnv("DB_PASSWORD", "password")
-	dbName := getEnv("DB_NAME", "webcrawler")
+// load layers the defaults, the config file, the environment and the
+// command-line arguments and validates the result
+func load(args []string) (*Config, error) {
+	config := defaultConfig()
+	config.args = args
+
+	// Flags are parsed first to find the config file but applied last
+	var flagValues []func() error
+	flags := flag.NewFlagSet("webcrawler", flag.ContinueOnError)
+	configFile := flags.String("config", getEnv("CONFIG_FILE", ""), "path to a YAML config file")
+	for _, s := range config.settings() {
+		if s.flag == "" {
+			continue
+		}
+		s := s
+		// The value is parsed when the flags are applied, naming the flag
+		set := func(raw string) error {
+			flagValues = append(flagValues, func() error {
+				if err := assign(s.value, raw); err != nil {
+					return fmt.Errorf("--%s: %v", s.flag, err)
+				}
+				return nil
+			})
+			return nil
+		}
+		// Boolean flags may be given without a value
+		if _, ok := s.value.(*bool); ok {
+			flags.BoolFunc(s.flag, s.usage, set)
+		} else {
+			flags.Func(s.flag, s.usage, set)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadFile(*configFile); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, s := range config.settings() {
+		if raw := os.Getenv(s.env); raw != "" {
+			if err := assign(s.value, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+	for _, apply := range flagValues {
+		if err := apply(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid environment or flags: %s", strings.Join(problems, "; "))
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadFile reads the YAML config file. Without an explicit path the default
+// file is read when it exists.
+func (c *Config) loadFile(path string) error {
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return nil
+		}
+		path = defaultConfigFile
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
 
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	c.ConfigFile = path
+	log.Printf("Loaded config file %s", path)
+	return nil
+}
+
+// Validate reports every setting that is out of range
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "port must be between 1 and 65535, got %q", c.Port)
+	check(c.JWTSecret != "", "JWT secret must not be empty")
+	check(len(c.AllowedOrigins) > 0, "at least one allowed origin is required")
+	check(c.DBHost != "", "database host must not be empty")
+	check(c.DBName != "", "database name must not be empty")
+	check(c.MaxConcurrentCrawls >= 1 && c.MaxConcurrentCrawls <= maxConcurrentCrawls,
+		"max concurrent crawls must be between 1 and %d, got %d", maxConcurrentCrawls, c.MaxConcurrentCrawls)
+	check(c.CrawlTimeout >= 1 && c.CrawlTimeout <= models.MaxCrawlTimeout,
+		"crawl timeout must be between 1 and %d seconds, got %d", models.MaxCrawlTimeout, c.CrawlTimeout)
+	check(c.MaxDepth >= 0 && c.MaxDepth <= models.MaxCrawlDepth,
+		"max depth must be between 0 and %d, got %d", models.MaxCrawlDepth, c.MaxDepth)
+	check(c.MaxPagesPerDomain >= 1 && c.MaxPagesPerDomain <= models.MaxCrawlPagesPerDomain,
+		"max pages per domain must be between 1 and %d, got %d", models.MaxCrawlPagesPerDomain, c.MaxPagesPerDomain)
+	check(c.HostRequestsPerSecond >= 0 && c.HostRequestsPerSecond <= maxHostRequestsPerSecond,
+		"host requests per second must be between 0 and %d, got %g", maxHostRequestsPerSecond, c.HostRequestsPerSecond)
+	check(c.HostMaxConnections >= 1 && c.HostMaxConnections <= maxHostConnections,
+		"host max connections must be between 1 and %d, got %d", maxHostConnections, c.HostMaxConnections)
+	check(c.AutoThrottleMaxDelay >= 1 && c.AutoThrottleMaxDelay <= maxAutoThrottleDelay,
+		"auto throttle max delay must be between 1 and %d seconds, got %d", maxAutoThrottleDelay, c.AutoThrottleMaxDelay)
+	check(c.RetryMaxAttempts >= 1 && c.RetryMaxAttempts <= models.MaxRetryAttempts,
+		"retry max attempts must be between 1 and %d, got %d", models.MaxRetryAttempts, c.RetryMaxAttempts)
+	check(c.RetryBaseDelay >= 0 && c.RetryBaseDelay <= models.MaxRetryDelayMs,
+		"retry base delay must be between 0 and %d milliseconds, got %d", models.MaxRetryDelayMs, c.RetryBaseDelay)
+	check(c.RetryMaxDelay >= c.RetryBaseDelay && c.RetryMaxDelay <= models.MaxRetryDelayMs,
+		"retry max delay must be between the base delay and %d milliseconds, got %d", models.MaxRetryDelayMs, c.RetryMaxDelay)
+	for _, class := range c.RetryOn {
+		check(models.IsRetryClass(class), "unknown retry class %q, expected one of %s", class, strings.Join(models.RetryClasses, ", "))
+	}
+	check(c.MaxBodySize >= minBodySize && c.MaxBodySize <= models.MaxCrawlBodySize,
+		"max body size must be between %d and %d bytes, got %d", minBodySize, models.MaxCrawlBodySize, c.MaxBodySize)
+	check(c.UserAgent != "" && httpguts.ValidHeaderFieldValue(c.UserAgent), "user agent must be a valid header value, got %q", c.UserAgent)
+	for _, entry := range c.DefaultHeaders {
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			check(false, "default header %q must have the form \"Name: Value\"", entry)
+			continue
+		}
+		if err := models.CheckHeader(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+			check(false, "default %v", err)
+		}
+	}
+	check(c.CredentialsKey == "" || len(c.CredentialsKey) >= minCredentialsKeyLength,
+		"credentials key must be at least %d characters long", minCredentialsKeyLength)
+	if c.ProxyURL != "" {
+		_, err := models.ParseProxyURL(c.ProxyURL)
+		check(err == nil, "%v", err)
+	}
+	check(c.MaxIdleConns >= 0, "max idle connections must not be negative, got %d", c.MaxIdleConns)
+	check(c.MaxIdleConnsPerHost >= 0, "max idle connections per host must not be negative, got %d", c.MaxIdleConnsPerHost)
+	check(c.MaxConnsPerHost >= 0, "max connections per host must not be negative, got %d", c.MaxConnsPerHost)
+	check(c.IdleConnTimeout >= 0, "idle connection timeout must not be negative, got %d", c.IdleConnTimeout)
+	check(c.DialTimeout >= 1 && c.DialTimeout <= models.MaxCrawlTimeout,
+		"dial timeout must be between 1 and %d seconds, got %d", models.MaxCrawlTimeout, c.DialTimeout)
+	check(c.TLSHandshakeTimeout >= 1 && c.TLSHandshakeTimeout <= models.MaxCrawlTimeout,
+		"TLS handshake timeout must be between 1 and %d seconds, got %d", models.MaxCrawlTimeout, c.TLSHandshakeTimeout)
+	check(c.ResponseHeaderTimeout >= 0 && c.ResponseHeaderTimeout <= models.MaxCrawlTimeout,
+		"response header timeout must be between 0 and %d seconds, got %d", models.MaxCrawlTimeout, c.ResponseHeaderTimeout)
+	check(c.DNSCacheTTL >= 0, "DNS cache TTL must not be negative, got %d", c.DNSCacheTTL)
+	check(c.OrphanedCrawlPolicy == "requeue" || c.OrphanedCrawlPolicy == "error",
+		"orphaned crawl policy must be \"requeue\" or \"error\", got %q", c.OrphanedCrawlPolicy)
+	check(c.CrawlLeaseTimeout > 0, "crawl lease timeout must be positive, got %d", c.CrawlLeaseTimeout)
+	check(c.ShutdownGracePeriod > 0, "shutdown grace period must be positive, got %d", c.ShutdownGracePeriod)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config that is safe to show, with secrets
+// replaced
+func (c *Config) Redacted() Config {
+	redacted := *c
+	redacted.DB = nil
+	if redacted.JWTSecret != "" {
+		redacted.JWTSecret = redactedValue
+	}
+	if redacted.DBPassword != "" {
+		redacted.DBPassword = redactedValue
+	}
+	if redacted.CredentialsKey != "" {
+		redacted.CredentialsKey = redactedValue
+	}
+	redacted.ProxyURL = models.RedactProxyURL(redacted.ProxyURL)
+	// Header values may carry tokens, so only the names are shown
+	if len(c.DefaultHeaders) > 0 {
+		redacted.DefaultHeaders = make([]string, len(c.DefaultHeaders))
+		for i, entry := range c.DefaultHeaders {
+			name, _, _ := strings.Cut(entry, ":")
+			redacted.DefaultHeaders[i] = strings.TrimSpace(name) + ": "
+		}
+	}
+	return redacted
+}
+
+// Headers returns the default headers by name
+func (c *Config) Headers() map[string]string {
+	headers := make(map[string]string, len(c.DefaultHeaders))
+	for _, entry := range c.DefaultHeaders {
+		if name, value, ok := strings.Cut(entry, ":"); ok {
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return headers
+}
+
+func (c *Config) initDB() {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		dbUser, dbPassword, dbHost, dbPort, dbName)
+		c.DBUser, c.DBPassword, c.DBHost, c.DBPort, c.DBName)
 
 	var err error
 	c.DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
@@ -75,7 +435,7 @@ func (c *Config) initDB() {
 	log.Println("Database connected successfully")
 
 	// Auto migrate the models
-	err = c.DB.AutoMigrate(&models.CrawlResult{}, &models.BrokenLink{})
+	err = c.DB.AutoMigrate(&models.CrawlResult{}, &models.BrokenLink{}, &models.CrawledPage{}, &models.SkippedURL{}, &models.SitemapURL{}, &models.CrawlJob{}, &models.RedirectChain{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
@@ -83,18 +443,52 @@ func (c *Config) initDB() {
 	log.Println("Database migration completed")
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// assign parses a raw string into a setting's field
+func assign(target interface{}, raw string) error {
+	switch value := target.(type) {
+	case *string:
+		*value = raw
+	case *int:
+		parsed, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		*value = parsed
+	case *bool:
+		parsed, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		*value = parsed
+	case *int64:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		*value = parsed
+	case *float64:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		*value = parsed
+	case *[]string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*value = items
+	default:
+		return fmt.Errorf("unsupported setting type %T", target)
 	}
-	return defaultValue
+	return nil
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
+func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
-		}
+		return value
 	}
 	return defaultValue
 }
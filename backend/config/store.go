@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// Store holds the effective configuration and replaces it on reload. Only
+// the runtime settings take effect on reload; the rest needs a restart.
+type Store struct {
+	mutex     sync.RWMutex
+	current   *Config
+	listeners []func(*Config)
+}
+
+func NewStore(cfg *Config) *Store {
+	return &Store{current: cfg}
+}
+
+// Current returns the effective configuration. It must not be modified.
+func (s *Store) Current() *Config {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.current
+}
+
+// OnReload registers a function that is called with every reloaded config
+func (s *Store) OnReload(listener func(*Config)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// Reload reads the config file and environment again. When the new config
+// is valid its runtime settings replace the current ones and the listeners
+// are notified; otherwise the current config stays in effect.
+func (s *Store) Reload() (*Config, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	loaded, err := load(s.current.args)
+	if err != nil {
+		return nil, fmt.Errorf("config not reloaded: %v", err)
+	}
+
+	// Keep everything but the runtime settings as the server was started
+	next := *s.current
+	next.MaxConcurrentCrawls = loaded.MaxConcurrentCrawls
+	next.CrawlTimeout = loaded.CrawlTimeout
+	next.MaxDepth = loaded.MaxDepth
+	next.MaxPagesPerDomain = loaded.MaxPagesPerDomain
+	next.UserAgent = loaded.UserAgent
+	next.DefaultHeaders = loaded.DefaultHeaders
+	next.ProxyURL = loaded.ProxyURL
+	next.MaxBodySize = loaded.MaxBodySize
+	next.HostRequestsPerSecond = loaded.HostRequestsPerSecond
+	next.HostMaxConnections = loaded.HostMaxConnections
+	next.AutoThrottle = loaded.AutoThrottle
+	next.AutoThrottleMaxDelay = loaded.AutoThrottleMaxDelay
+	next.RetryMaxAttempts = loaded.RetryMaxAttempts
+	next.RetryBaseDelay = loaded.RetryBaseDelay
+	next.RetryMaxDelay = loaded.RetryMaxDelay
+	next.RetryOn = loaded.RetryOn
+
+	loaded.DB = next.DB
+	loaded.ConfigFile = next.ConfigFile
+	if !reflect.DeepEqual(*loaded, next) {
+		log.Println("Config reloaded; changes to settings other than crawler limits, headers and proxy need a restart")
+	}
+
+	s.current = &next
+	for _, listener := range s.listeners {
+		listener(s.current)
+	}
+	log.Printf("Config reloaded: %d concurrent crawls, %ds timeout, depth %d, %d pages per domain, user agent %q, %g requests/s and %d connections per host, auto throttle %t up to %ds, %d attempts per request",
+		next.MaxConcurrentCrawls, next.CrawlTimeout, next.MaxDepth, next.MaxPagesPerDomain, next.UserAgent,
+		next.HostRequestsPerSecond, next.HostMaxConnections, next.AutoThrottle, next.AutoThrottleMaxDelay, next.RetryMaxAttempts)
+	return s.current, nil
+}
@@ -9,6 +9,7 @@ require (
 	github.com/google/uuid v1.4.0
 	github.com/joho/godotenv v1.4.0
 	golang.org/x/net v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/mysql v1.5.2
 	gorm.io/gorm v1.25.5
 )
@@ -39,5 +40,4 @@ require (
 	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+
+	"webcrawler/config"
+	"webcrawler/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	configStore    *config.Store
+	crawlerService *services.CrawlerService
+}
+
+func NewAdminHandler(configStore *config.Store, crawlerService *services.CrawlerService) *AdminHandler {
+	return &AdminHandler{
+		configStore:    configStore,
+		crawlerService: crawlerService,
+	}
+}
+
+// GetConfig returns the effective configuration with secrets redacted
+func (h *AdminHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, h.configStore.Current().Redacted())
+}
+
+// ReloadConfig rereads the config file and environment and applies the new
+// crawler limits and user agent to crawls that start afterwards
+func (h *AdminHandler) ReloadConfig(c *gin.Context) {
+	cfg, err := h.configStore.Reload()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, cfg.Redacted())
+}
+
+// GetTransportStats reports connection reuse and DNS cache use of the
+// crawler's shared HTTP transport
+func (h *AdminHandler) GetTransportStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.crawlerService.TransportStats())
+}
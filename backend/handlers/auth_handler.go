@@ -53,7 +53,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// For demo purposes, we'll use a simple authentication
 	// In production, you would validate against a database
 	if req.Username == "admin" && req.Password == "password" {
-		token, expiresAt, err := h.authService.GenerateToken(req.Username)
+		token, expiresAt, err := h.authService.GenerateToken(req.Username, services.RoleAdmin)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 			return
@@ -86,7 +86,8 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	// For demo purposes, we'll accept any registration
 	// In production, you would save to database and hash passwords
-	token, expiresAt, err := h.authService.GenerateToken(req.Username)
+	// Registered users never get the admin role
+	token, expiresAt, err := h.authService.GenerateToken(req.Username, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -3,7 +3,9 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
+	"webcrawler/models"
 	"webcrawler/services"
 
 	"github.com/gin-gonic/gin"
@@ -20,19 +22,30 @@ func NewCrawlHandler(crawlerService *services.CrawlerService) *CrawlHandler {
 }
 
 type SubmitURLRequest struct {
-	URL string `json:"url" binding:"required,url"`
+	URL     string               `json:"url" binding:"required,url"`
+	Options *models.CrawlOptions `json:"options"`
 }
 
 type BulkOperationRequest struct {
 	IDs []string `json:"ids" binding:"required"`
 }
 
+type StartCrawlRequest struct {
+	Priority int `json:"priority"`
+}
+
+type BulkStartRequest struct {
+	IDs      []string `json:"ids" binding:"required"`
+	Priority int      `json:"priority"`
+}
+
 type StatsResponse struct {
 	TotalCrawls     int64 `json:"totalCrawls"`
 	CompletedCrawls int64 `json:"completedCrawls"`
 	QueuedCrawls    int64 `json:"queuedCrawls"`
 	RunningCrawls   int64 `json:"runningCrawls"`
 	ErrorCrawls     int64 `json:"errorCrawls"`
+	CancelledCrawls int64 `json:"cancelledCrawls"`
 }
 
 // SubmitURL submits a new URL for crawling
@@ -43,7 +56,16 @@ func (h *CrawlHandler) SubmitURL(c *gin.Context) {
 		return
 	}
 
-	crawlResult, err := h.crawlerService.SubmitURL(req.URL)
+	if err := req.Options.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Options != nil && req.Options.Auth != nil && !h.crawlerService.AcceptsCredentials() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "auth options require CREDENTIALS_KEY to be configured on the server"})
+		return
+	}
+
+	crawlResult, err := h.crawlerService.SubmitURL(req.URL, req.Options)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit URL: " + err.Error()})
 		return
@@ -52,17 +74,46 @@ func (h *CrawlHandler) SubmitURL(c *gin.Context) {
 	c.JSON(http.StatusCreated, crawlResult)
 }
 
+// PreviewScope reports which URLs on the seed page would be in scope for a
+// crawl with the submitted options, without creating a crawl
+func (h *CrawlHandler) PreviewScope(c *gin.Context) {
+	var req SubmitURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := req.Options.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preview, err := h.crawlerService.PreviewScope(c.Request.Context(), req.URL, req.Options)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch seed page: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
 // GetAllCrawls retrieves all crawl results with pagination and filtering
 func (h *CrawlHandler) GetAllCrawls(c *gin.Context) {
 	// Parse query parameters
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 	status := c.Query("status")
+	errorCategory := c.Query("errorCategory")
 	search := c.Query("search")
 	sortBy := c.DefaultQuery("sortBy", "createdAt")
 	sortOrder := c.DefaultQuery("sortOrder", "desc")
 
-	crawls, total, err := h.crawlerService.GetAllCrawls(page, limit, status, search, sortBy, sortOrder)
+	if errorCategory != "" && !models.IsErrorCategory(errorCategory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid errorCategory, expected one of " + strings.Join(models.ErrorCategories, ", ")})
+		return
+	}
+
+	crawls, total, err := h.crawlerService.GetAllCrawls(page, limit, status, errorCategory, search, sortBy, sortOrder)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve crawls: " + err.Error()})
 		return
@@ -90,6 +141,19 @@ func (h *CrawlHandler) GetCrawlResult(c *gin.Context) {
 	c.JSON(http.StatusOK, crawlResult)
 }
 
+// GetSitemapReport compares a crawl's sitemap with the pages it linked
+func (h *CrawlHandler) GetSitemapReport(c *gin.Context) {
+	id := c.Param("id")
+
+	report, err := h.crawlerService.GetSitemapReport(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Crawl result not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
 // DeleteCrawl deletes a crawl result
 func (h *CrawlHandler) DeleteCrawl(c *gin.Context) {
 	id := c.Param("id")
@@ -103,17 +167,25 @@ func (h *CrawlHandler) DeleteCrawl(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Crawl deleted successfully"})
 }
 
-// StartCrawl starts crawling for a specific URL
+// StartCrawl queues crawling for a specific URL. The body is optional.
 func (h *CrawlHandler) StartCrawl(c *gin.Context) {
 	id := c.Param("id")
 
-	err := h.crawlerService.StartCrawl(id)
+	var req StartCrawlRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := h.crawlerService.StartCrawl(id, req.Priority)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start crawl: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Crawl started successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Crawl queued successfully"})
 }
 
 // StopCrawl stops crawling for a specific URL
@@ -146,21 +218,26 @@ func (h *CrawlHandler) BulkDelete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Crawls deleted successfully"})
 }
 
-// BulkStart starts crawling for multiple URLs
+// BulkStart queues crawling for multiple URLs and reports the IDs that
+// could not be queued
 func (h *CrawlHandler) BulkStart(c *gin.Context) {
-	var req BulkOperationRequest
+	var req BulkStartRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := h.crawlerService.BulkStart(req.IDs)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start crawls: " + err.Error()})
+	failed := h.crawlerService.BulkStart(req.IDs, req.Priority)
+	if len(failed) == len(req.IDs) && len(failed) > 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start crawls", "failed": failed})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Crawls started successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Crawls queued successfully",
+		"queued":  len(req.IDs) - len(failed),
+		"failed":  failed,
+	})
 }
 
 // GetStats returns crawling statistics
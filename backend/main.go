@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"webcrawler/config"
 	"webcrawler/handlers"
@@ -20,15 +27,24 @@ func main() {
 	}
 
 	// Load configuration and initialize database
-	cfg := config.LoadConfig()
+	cfg, err := config.LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+
+	// Crawler limits and the user agent can be reloaded at runtime
+	configStore := config.NewStore(cfg)
 
 	// Initialize services
-	crawlerService := services.NewCrawlerService(cfg.DB)
-	authService := services.NewAuthService()
+	crawlerService := services.NewCrawlerService(cfg)
+	configStore.OnReload(crawlerService.ApplyConfig)
+	crawlerService.StartWorkers()
+	authService := services.NewAuthService(cfg.JWTSecret)
 
 	// Initialize handlers
 	crawlHandler := handlers.NewCrawlHandler(crawlerService)
 	authHandler := handlers.NewAuthHandler(authService)
+	adminHandler := handlers.NewAdminHandler(configStore, crawlerService)
 
 	// Setup Gin router
 	router := gin.Default()
@@ -56,12 +72,14 @@ func main() {
 
 	// Protected API routes
 	api := router.Group("/api")
-	api.Use(middleware.AuthMiddleware())
+	api.Use(middleware.AuthMiddleware(authService))
 	{
 		// URL management
 		api.POST("/urls", crawlHandler.SubmitURL)
+		api.POST("/urls/scope-preview", crawlHandler.PreviewScope)
 		api.GET("/urls", crawlHandler.GetAllCrawls)
 		api.GET("/urls/:id", crawlHandler.GetCrawlResult)
+		api.GET("/urls/:id/sitemap-report", crawlHandler.GetSitemapReport)
 		api.DELETE("/urls/:id", crawlHandler.DeleteCrawl)
 
 		// Crawl operations
@@ -74,13 +92,66 @@ func main() {
 
 		// Statistics
 		api.GET("/stats", crawlHandler.GetStats)
+
+	}
+
+	// Administration routes are limited to admins
+	admin := api.Group("/admin")
+	admin.Use(middleware.RequireAdmin())
+	{
+		admin.GET("/config", adminHandler.GetConfig)
+		admin.POST("/config/reload", adminHandler.ReloadConfig)
+		admin.GET("/transport", adminHandler.GetTransportStats)
 	}
 
 	// Get port from config
 	port := cfg.Port
 
-	log.Printf("Server starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Reload the runtime settings on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if _, err := configStore.Reload(); err != nil {
+				log.Printf("Failed to reload config: %v", err)
+			}
+		}
+	}()
+
+	// Wait for an interrupt or termination signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server...")
+
+	gracePeriod := time.Duration(cfg.ShutdownGracePeriod) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	// Stop accepting new crawls and requests at once; running crawls get the
+	// grace period before they are checkpointed and requeued
+	crawlsStopped := make(chan error, 1)
+	go func() {
+		crawlsStopped <- crawlerService.Shutdown(ctx)
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to shut down: %v", err)
 	}
+	if err := <-crawlsStopped; err != nil {
+		log.Printf("Crawls checkpointed after grace period: %v", err)
+	}
+
+	log.Println("Server stopped")
 }
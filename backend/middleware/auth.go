@@ -10,9 +10,7 @@ import (
 )
 
 // AuthMiddleware validates JWT tokens
-func AuthMiddleware() gin.HandlerFunc {
-	authService := services.NewAuthService()
-
+func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -30,7 +28,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Validate token
-		username, err := authService.ValidateToken(tokenString)
+		claims, err := authService.ValidateToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
@@ -38,7 +36,21 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Set user context
-		c.Set("username", username)
+		c.Set("username", claims.Username)
+		c.Set("role", claims.Role)
+		c.Next()
+	}
+}
+
+// RequireAdmin only lets users with the admin role through. It runs after
+// AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != services.RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+)
+
+// CrawlJob is an entry in the persistent crawl queue. Jobs are claimed by
+// the worker pool in priority order, oldest first within the same priority,
+// and removed once their crawl has finished. A running job holds a lease
+// that its worker renews through HeartbeatAt.
+type CrawlJob struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	CrawlResultID string     `json:"crawlResultId" gorm:"not null;index"`
+	Priority      int        `json:"priority" gorm:"index"`
+	Status        JobStatus  `json:"status" gorm:"index;default:'pending'"`
+	Attempts      int        `json:"attempts"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	HeartbeatAt   *time.Time `json:"heartbeatAt,omitempty" gorm:"index"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
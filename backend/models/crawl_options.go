@@ -0,0 +1,469 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// CrawlOptions holds the settings a crawl was submitted with. Zero values
+// fall back to the crawler's defaults.
+type CrawlOptions struct {
+	// Timeout is the per-request timeout in seconds
+	Timeout int `json:"timeout,omitempty"`
+	// MaxDepth is how many links deep the crawl follows; 0 crawls only the
+	// seed page and sitemap entries
+	MaxDepth *int `json:"maxDepth,omitempty"`
+	// MaxPagesPerDomain is the number of pages fetched per host
+	MaxPagesPerDomain int               `json:"maxPagesPerDomain,omitempty"`
+	UserAgent         string            `json:"userAgent,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	// Cookies seed the crawl's cookie jar. They are sent with requests to the
+	// crawled site, never to external hosts, unless the site replaces them.
+	Cookies map[string]string `json:"cookies,omitempty"`
+	// FollowExternal also fetches the external pages the site links to,
+	// without following their links
+	FollowExternal bool `json:"followExternal,omitempty"`
+	// MaxBodySize is the largest page body in bytes that is read
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
+	// Proxy is the URL of an http, https or socks5 proxy the crawl's requests
+	// go through, optionally with user:password credentials
+	Proxy string `json:"proxy,omitempty"`
+
+	LinkCheck      *LinkCheckOptions      `json:"linkCheck,omitempty"`
+	Normalization  *NormalizationOptions  `json:"normalization,omitempty"`
+	Classification *ClassificationOptions `json:"classification,omitempty"`
+	Scope          *ScopeOptions          `json:"scope,omitempty"`
+	Retry          *RetryOptions          `json:"retry,omitempty"`
+	Auth           *AuthOptions           `json:"auth,omitempty"`
+}
+
+// LinkCheckOptions limits how links are checked for being broken
+type LinkCheckOptions struct {
+	// MaxLinks caps the number of links checked per crawl; 0 checks every link
+	MaxLinks int `json:"maxLinks,omitempty"`
+	// Concurrency is the number of links checked in parallel
+	Concurrency int `json:"concurrency,omitempty"`
+	// PerHostConcurrency is the number of links checked in parallel on one host
+	PerHostConcurrency int `json:"perHostConcurrency,omitempty"`
+}
+
+// NormalizationOptions turns off parts of URL canonicalization. Scheme and
+// host case, default ports, fragments and dot segments are always normalized.
+type NormalizationOptions struct {
+	// PreserveQueryOrder keeps query parameters in their original order
+	PreserveQueryOrder bool `json:"preserveQueryOrder,omitempty"`
+	// PreserveTrackingParams keeps utm_*, fbclid and similar parameters
+	PreserveTrackingParams bool `json:"preserveTrackingParams,omitempty"`
+	// PreserveTrailingSlash treats /about and /about/ as different pages
+	PreserveTrailingSlash bool `json:"preserveTrailingSlash,omitempty"`
+}
+
+// Link classification modes
+const (
+	// ClassifyByHost treats links to the seed's host as internal
+	ClassifyByHost = "host"
+	// ClassifyByDomain treats links to the seed's registrable domain, such as
+	// other subdomains, as internal
+	ClassifyByDomain = "domain"
+	// ClassifyByDomainList treats links to the listed domains and their
+	// subdomains as internal, in addition to the seed's host
+	ClassifyByDomainList = "custom"
+)
+
+// ClassificationOptions decides which links count as internal
+type ClassificationOptions struct {
+	// Mode is ClassifyByHost (the default), ClassifyByDomain or ClassifyByDomainList
+	Mode string `json:"mode,omitempty"`
+	// Domains are the internal domains in ClassifyByDomainList mode
+	Domains []string `json:"domains,omitempty"`
+}
+
+// Classes of transient failures a request can be retried after
+const (
+	RetryTimeout            = "timeout"
+	RetryConnectionReset    = "connection_reset"
+	RetryTooManyRequests    = "429"
+	RetryBadGateway         = "502"
+	RetryServiceUnavailable = "503"
+	RetryGatewayTimeout     = "504"
+)
+
+// RetryClasses lists every retryable failure class
+var RetryClasses = []string{
+	RetryTimeout,
+	RetryConnectionReset,
+	RetryTooManyRequests,
+	RetryBadGateway,
+	RetryServiceUnavailable,
+	RetryGatewayTimeout,
+}
+
+// IsRetryClass reports whether class is one of RetryClasses
+func IsRetryClass(class string) bool {
+	for _, known := range RetryClasses {
+		if class == known {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryOptions overrides the crawler's retry policy for transient failures
+// of page fetches and link checks
+type RetryOptions struct {
+	// MaxAttempts is how often a request is tried in total; 1 disables retries
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// BaseDelayMs is the backoff before the first retry, doubled for every
+	// further retry and randomized by up to half
+	BaseDelayMs int `json:"baseDelayMs,omitempty"`
+	// MaxDelayMs caps the backoff between two attempts
+	MaxDelayMs int `json:"maxDelayMs,omitempty"`
+	// RetryOn lists the failure classes that are retried
+	RetryOn []string `json:"retryOn,omitempty"`
+}
+
+// Ways a crawl can authenticate with the crawled site
+const (
+	AuthBasic  = "basic"
+	AuthDigest = "digest"
+	AuthBearer = "bearer"
+	AuthForm   = "form"
+)
+
+// AuthOptions are the credentials a crawl logs in to the crawled site with.
+// They are only sent to the crawled site, are stored encrypted and the
+// password and token are never returned by the API.
+type AuthOptions struct {
+	// Type is AuthBasic, AuthDigest, AuthBearer or AuthForm
+	Type     string `json:"type"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Token is the bearer token
+	Token string `json:"token,omitempty"`
+	// Form configures the form login; without it the login form is detected
+	// on the seed page
+	Form *FormLoginOptions `json:"form,omitempty"`
+}
+
+// FormLoginOptions configures how the credentials of a form login are posted
+type FormLoginOptions struct {
+	// LoginURL is the page with the login form, the seed page by default
+	LoginURL string `json:"loginUrl,omitempty"`
+	// Action is the URL the credentials are posted to. When set the login
+	// page is not fetched and only the configured fields are posted.
+	Action string `json:"action,omitempty"`
+	// UsernameField and PasswordField are the names of the credential inputs,
+	// detected from the form when empty
+	UsernameField string `json:"usernameField,omitempty"`
+	PasswordField string `json:"passwordField,omitempty"`
+	// Fields are further non-secret values posted with the form, such as a
+	// "remember me" checkbox
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Redacted returns a copy of the auth options without password and token
+func (a *AuthOptions) Redacted() *AuthOptions {
+	if a == nil {
+		return nil
+	}
+	redacted := *a
+	redacted.Password = ""
+	redacted.Token = ""
+	return &redacted
+}
+
+// validate checks that the credentials needed by the auth type are given
+func (a *AuthOptions) validate() error {
+	switch a.Type {
+	case AuthBasic, AuthDigest:
+		if a.Username == "" {
+			return fmt.Errorf("auth.username is required for %s auth", a.Type)
+		}
+	case AuthBearer:
+		if a.Token == "" || !httpguts.ValidHeaderFieldValue(a.Token) {
+			return fmt.Errorf("auth.token is required for bearer auth and must be a valid header value")
+		}
+	case AuthForm:
+		if a.Username == "" || a.Password == "" {
+			return fmt.Errorf("auth.username and auth.password are required for form auth")
+		}
+	default:
+		return fmt.Errorf("auth.type must be one of %q, %q, %q or %q", AuthBasic, AuthDigest, AuthBearer, AuthForm)
+	}
+	if a.Type == AuthBasic && strings.Contains(a.Username, ":") {
+		return fmt.Errorf("auth.username must not contain a colon for basic auth")
+	}
+
+	form := a.Form
+	if form == nil {
+		return nil
+	}
+	if a.Type != AuthForm {
+		return fmt.Errorf("auth.form is only used with form auth")
+	}
+	for _, field := range []struct{ name, value string }{{"loginUrl", form.LoginURL}, {"action", form.Action}} {
+		if field.value == "" {
+			continue
+		}
+		if u, err := url.Parse(field.value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("auth.form.%s must be an absolute http or https URL", field.name)
+		}
+	}
+	if form.Action != "" && (form.UsernameField == "" || form.PasswordField == "") {
+		return fmt.Errorf("auth.form.usernameField and auth.form.passwordField are required with auth.form.action")
+	}
+	return nil
+}
+
+// Scope pattern syntaxes
+const (
+	// PatternGlob patterns use "*" to match any run of characters
+	PatternGlob = "glob"
+	// PatternRegex patterns are regular expressions
+	PatternRegex = "regex"
+)
+
+// ScopeOptions limits the URLs a crawl follows and checks. Include and
+// exclude patterns match the path plus the query string, e.g. "/shop?color=red".
+type ScopeOptions struct {
+	// PatternType is PatternGlob (the default) or PatternRegex
+	PatternType string `json:"patternType,omitempty"`
+	// Include, when set, keeps only URLs matching at least one pattern
+	Include []string `json:"include,omitempty"`
+	// Exclude drops URLs matching any pattern
+	Exclude []string `json:"exclude,omitempty"`
+	// AllowedHosts, when set, keeps only URLs on these hosts. "*.example.com"
+	// allows the subdomains of example.com.
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+	// ExcludeExtensions drops URLs whose path ends in one of these file
+	// extensions, e.g. "pdf" or ".zip"
+	ExcludeExtensions []string `json:"excludeExtensions,omitempty"`
+}
+
+// validate checks the pattern type and compiles every pattern
+func (s *ScopeOptions) validate() error {
+	if s.PatternType != "" && s.PatternType != PatternGlob && s.PatternType != PatternRegex {
+		return fmt.Errorf("scope.patternType must be %q or %q", PatternGlob, PatternRegex)
+	}
+	for _, pattern := range s.Include {
+		if _, err := CompileScopePattern(pattern, s.PatternType); err != nil {
+			return fmt.Errorf("scope.include pattern %q is invalid: %v", pattern, err)
+		}
+	}
+	for _, pattern := range s.Exclude {
+		if _, err := CompileScopePattern(pattern, s.PatternType); err != nil {
+			return fmt.Errorf("scope.exclude pattern %q is invalid: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// CompileScopePattern compiles an include or exclude pattern. Glob patterns
+// must match the whole path and query; regular expressions match anywhere
+// unless anchored.
+func CompileScopePattern(pattern, patternType string) (*regexp.Regexp, error) {
+	if patternType == PatternRegex {
+		return regexp.Compile(pattern)
+	}
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+const (
+	MaxCrawlTimeout                = 300
+	MaxCrawlDepth                  = 10
+	MaxCrawlPagesPerDomain         = 10000
+	MaxLinkCheckConcurrency        = 50
+	MaxLinkCheckPerHostConcurrency = 10
+	MaxCrawlBodySize               = 100 * 1024 * 1024
+	MaxRetryAttempts               = 10
+	MaxRetryDelayMs                = 60000
+)
+
+// reservedHeaders are managed by the crawler and can't be set as headers
+var reservedHeaders = map[string]bool{
+	"Host":                true,
+	"Content-Length":      true,
+	"Connection":          true,
+	"Transfer-Encoding":   true,
+	"Cookie":              true,
+	"Authorization":       true,
+	"User-Agent":          true,
+	"Proxy-Authorization": true,
+}
+
+// CheckHeader reports whether a header can be sent with the crawler's requests
+func CheckHeader(name, value string) error {
+	if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("header %q is invalid", name)
+	}
+	if reservedHeaders[http.CanonicalHeaderKey(name)] {
+		return fmt.Errorf("header %q is managed by the crawler and can't be set", name)
+	}
+	return nil
+}
+
+// proxySchemes are the supported proxy protocols
+var proxySchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"socks5": true,
+}
+
+// ParseProxyURL parses an http, https or socks5 proxy URL. Its errors never
+// include the URL, which may hold credentials.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("proxy URL is malformed")
+	}
+	if !proxySchemes[proxy.Scheme] {
+		return nil, fmt.Errorf("proxy scheme must be http, https or socks5")
+	}
+	if proxy.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL has no host")
+	}
+	if (proxy.Path != "" && proxy.Path != "/") || proxy.RawQuery != "" {
+		return nil, fmt.Errorf("proxy URL must not have a path or query")
+	}
+	return proxy, nil
+}
+
+// RedactProxyURL hides the password of a proxy URL
+func RedactProxyURL(raw string) string {
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return proxy.Redacted()
+}
+
+// Redacted returns a copy of the options that is safe to return from the
+// API, with the proxy password, the header and cookie values and the auth
+// secrets hidden
+func (o *CrawlOptions) Redacted() *CrawlOptions {
+	if o == nil || (o.Proxy == "" && o.Auth == nil && len(o.Headers) == 0 && len(o.Cookies) == 0) {
+		return o
+	}
+	redacted := *o
+	if o.Proxy != "" {
+		redacted.Proxy = RedactProxyURL(o.Proxy)
+	}
+	redacted.Headers = redactValues(o.Headers)
+	redacted.Cookies = redactValues(o.Cookies)
+	redacted.Auth = o.Auth.Redacted()
+	return &redacted
+}
+
+// redactValues returns a copy of a map with its values blanked
+func redactValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(values))
+	for name := range values {
+		redacted[name] = ""
+	}
+	return redacted
+}
+
+// Validate checks that the options are within the accepted ranges
+func (o *CrawlOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Timeout < 0 || o.Timeout > MaxCrawlTimeout {
+		return fmt.Errorf("timeout must be between 0 and %d seconds", MaxCrawlTimeout)
+	}
+	if o.MaxDepth != nil && (*o.MaxDepth < 0 || *o.MaxDepth > MaxCrawlDepth) {
+		return fmt.Errorf("maxDepth must be between 0 and %d", MaxCrawlDepth)
+	}
+	if o.MaxPagesPerDomain < 0 || o.MaxPagesPerDomain > MaxCrawlPagesPerDomain {
+		return fmt.Errorf("maxPagesPerDomain must be between 0 and %d", MaxCrawlPagesPerDomain)
+	}
+	if o.MaxBodySize < 0 || o.MaxBodySize > MaxCrawlBodySize {
+		return fmt.Errorf("maxBodySize must be between 0 and %d bytes", MaxCrawlBodySize)
+	}
+	if !httpguts.ValidHeaderFieldValue(o.UserAgent) {
+		return fmt.Errorf("userAgent contains invalid characters")
+	}
+	for name, value := range o.Headers {
+		if err := CheckHeader(name, value); err != nil {
+			return err
+		}
+	}
+	for name, value := range o.Cookies {
+		cookie := &http.Cookie{Name: name, Value: value}
+		if cookie.Valid() != nil {
+			return fmt.Errorf("cookie %q is invalid", name)
+		}
+	}
+	if o.Proxy != "" {
+		if _, err := ParseProxyURL(o.Proxy); err != nil {
+			return fmt.Errorf("proxy is invalid: %v", err)
+		}
+	}
+	if o.Auth != nil {
+		if err := o.Auth.validate(); err != nil {
+			return err
+		}
+	}
+	if o.Scope != nil {
+		if err := o.Scope.validate(); err != nil {
+			return err
+		}
+	}
+	if lc := o.LinkCheck; lc != nil {
+		if lc.MaxLinks < 0 {
+			return fmt.Errorf("linkCheck.maxLinks must not be negative")
+		}
+		if lc.Concurrency < 0 || lc.Concurrency > MaxLinkCheckConcurrency {
+			return fmt.Errorf("linkCheck.concurrency must be between 0 and %d", MaxLinkCheckConcurrency)
+		}
+		if lc.PerHostConcurrency < 0 || lc.PerHostConcurrency > MaxLinkCheckPerHostConcurrency {
+			return fmt.Errorf("linkCheck.perHostConcurrency must be between 0 and %d", MaxLinkCheckPerHostConcurrency)
+		}
+	}
+	if r := o.Retry; r != nil {
+		if r.MaxAttempts < 0 || r.MaxAttempts > MaxRetryAttempts {
+			return fmt.Errorf("retry.maxAttempts must be between 0 and %d", MaxRetryAttempts)
+		}
+		if r.BaseDelayMs < 0 || r.BaseDelayMs > MaxRetryDelayMs {
+			return fmt.Errorf("retry.baseDelayMs must be between 0 and %d", MaxRetryDelayMs)
+		}
+		if r.MaxDelayMs < 0 || r.MaxDelayMs > MaxRetryDelayMs {
+			return fmt.Errorf("retry.maxDelayMs must be between 0 and %d", MaxRetryDelayMs)
+		}
+		for _, class := range r.RetryOn {
+			if !IsRetryClass(class) {
+				return fmt.Errorf("retry.retryOn contains unknown class %q, expected one of %s", class, strings.Join(RetryClasses, ", "))
+			}
+		}
+	}
+	if c := o.Classification; c != nil {
+		switch c.Mode {
+		case "", ClassifyByHost, ClassifyByDomain:
+		case ClassifyByDomainList:
+			if len(c.Domains) == 0 {
+				return fmt.Errorf("classification.domains is required in %q mode", ClassifyByDomainList)
+			}
+		default:
+			return fmt.Errorf("classification.mode must be one of %q, %q or %q", ClassifyByHost, ClassifyByDomain, ClassifyByDomainList)
+		}
+		for _, domain := range c.Domains {
+			if domain == "" || strings.ContainsAny(domain, "/: ") {
+				return fmt.Errorf("classification.domains contains an invalid domain %q", domain)
+			}
+		}
+	}
+	return nil
+}
@@ -14,39 +14,120 @@ const (
 	StatusRunning   CrawlStatus = "running"
 	StatusCompleted CrawlStatus = "completed"
 	StatusError     CrawlStatus = "error"
+	StatusCancelled CrawlStatus = "cancelled"
 )
 
 type CrawlResult struct {
-	ID                  string         `json:"id" gorm:"primaryKey"`
-	URL                 string         `json:"url" gorm:"not null;index"`
-	Title               string         `json:"title"`
-	HTMLVersion         string         `json:"htmlVersion"`
-	H1Count             int            `json:"-" gorm:"column:h1_count"`
-	H2Count             int            `json:"-" gorm:"column:h2_count"`
-	H3Count             int            `json:"-" gorm:"column:h3_count"`
-	H4Count             int            `json:"-" gorm:"column:h4_count"`
-	H5Count             int            `json:"-" gorm:"column:h5_count"`
-	H6Count             int            `json:"-" gorm:"column:h6_count"`
-	InternalLinksCount  int            `json:"internalLinksCount"`
-	ExternalLinksCount  int            `json:"externalLinksCount"`
-	BrokenLinksCount    int            `json:"brokenLinksCount"`
-	HasLoginForm        bool           `json:"hasLoginForm"`
-	Status              CrawlStatus    `json:"status" gorm:"default:'queued'"`
-	ErrorMessage        *string        `json:"errorMessage,omitempty"`
-	CrawledAt           time.Time      `json:"crawledAt"`
-	CreatedAt           time.Time      `json:"-"`
-	UpdatedAt           time.Time      `json:"-"`
-	DeletedAt           gorm.DeletedAt `json:"-" gorm:"index"`
-	BrokenLinks         []BrokenLink   `json:"brokenLinks" gorm:"foreignKey:CrawlResultID"`
+	ID                 string            `json:"id" gorm:"primaryKey"`
+	URL                string            `json:"url" gorm:"not null;index"`
+	Title              string            `json:"title"`
+	ContentType        string            `json:"contentType,omitempty"`
+	HTMLVersion        string            `json:"htmlVersion"`
+	H1Count            int               `json:"-" gorm:"column:h1_count"`
+	H2Count            int               `json:"-" gorm:"column:h2_count"`
+	H3Count            int               `json:"-" gorm:"column:h3_count"`
+	H4Count            int               `json:"-" gorm:"column:h4_count"`
+	H5Count            int               `json:"-" gorm:"column:h5_count"`
+	H6Count            int               `json:"-" gorm:"column:h6_count"`
+	InternalLinksCount int               `json:"internalLinksCount"`
+	ExternalLinksCount int               `json:"externalLinksCount"`
+	SpecialLinkCounts  SpecialLinkCounts `json:"specialLinkCounts" gorm:"embedded;embeddedPrefix:special_links_"`
+	BrokenLinksCount   int               `json:"brokenLinksCount"`
+	HasLoginForm       bool              `json:"hasLoginForm"`
+	PagesCrawled       int               `json:"pagesCrawled"`
+	// Attempts is the number of times the seed page was requested
+	Attempts      int           `json:"attempts"`
+	Status        CrawlStatus   `json:"status" gorm:"default:'queued'"`
+	QueuePosition int           `json:"queuePosition,omitempty" gorm:"-"`
+	HostDelays    []HostDelay   `json:"hostDelays,omitempty" gorm:"-"`
+	Options       *CrawlOptions `json:"options,omitempty" gorm:"type:json;serializer:json"`
+	// Credentials are the crawl's auth options, encrypted; Options only keeps
+	// a redacted copy
+	Credentials  string  `json:"-" gorm:"type:text"`
+	ErrorMessage *string `json:"errorMessage,omitempty"`
+	// ErrorCategory classifies the failure, one of ErrorCategories
+	ErrorCategory  string          `json:"errorCategory,omitempty" gorm:"index"`
+	CrawledAt      time.Time       `json:"crawledAt"`
+	CreatedAt      time.Time       `json:"-"`
+	UpdatedAt      time.Time       `json:"-"`
+	DeletedAt      gorm.DeletedAt  `json:"-" gorm:"index"`
+	BrokenLinks    []BrokenLink    `json:"brokenLinks" gorm:"foreignKey:CrawlResultID"`
+	Pages          []CrawledPage   `json:"pages,omitempty" gorm:"foreignKey:CrawlResultID"`
+	SkippedURLs    []SkippedURL    `json:"skippedUrls,omitempty" gorm:"foreignKey:CrawlResultID"`
+	RedirectChains []RedirectChain `json:"redirectChains,omitempty" gorm:"foreignKey:CrawlResultID"`
+}
+
+// CrawledPage holds the metrics of a single page visited during a site crawl
+type CrawledPage struct {
+	ID            uint   `json:"-" gorm:"primaryKey"`
+	CrawlResultID string `json:"-" gorm:"not null;index"`
+	URL           string `json:"url" gorm:"not null"`
+	Depth         int    `json:"depth"`
+	StatusCode    int    `json:"statusCode"`
+	// ContentType is the media type; pages that aren't HTML are not parsed
+	ContentType        string            `json:"contentType,omitempty"`
+	Title              string            `json:"title"`
+	HTMLVersion        string            `json:"htmlVersion"`
+	HeadingCounts      HeadingCounts     `json:"headingCounts" gorm:"embedded;embeddedPrefix:heading_"`
+	InternalLinksCount int               `json:"internalLinksCount"`
+	ExternalLinksCount int               `json:"externalLinksCount"`
+	SpecialLinkCounts  SpecialLinkCounts `json:"specialLinkCounts" gorm:"embedded;embeddedPrefix:special_links_"`
+	BrokenLinksCount   int               `json:"brokenLinksCount"`
+	HasLoginForm       bool              `json:"hasLoginForm"`
+	Attempts           int               `json:"attempts"`
+	RedirectedTo       string            `json:"redirectedTo,omitempty"`
+	ErrorMessage       *string           `json:"errorMessage,omitempty"`
+	ErrorCategory      string            `json:"errorCategory,omitempty"`
+	CrawledAt          time.Time         `json:"crawledAt"`
+	CreatedAt          time.Time         `json:"-"`
 }
 
 type BrokenLink struct {
-	ID             uint   `json:"-" gorm:"primaryKey"`
-	CrawlResultID  string `json:"-" gorm:"not null;index"`
-	URL            string `json:"url" gorm:"not null"`
-	StatusCode     int    `json:"statusCode"`
-	Text           string `json:"text"`
-	CreatedAt      time.Time      `json:"-"`
+	ID            uint   `json:"-" gorm:"primaryKey"`
+	CrawlResultID string `json:"-" gorm:"not null;index"`
+	URL           string `json:"url" gorm:"not null"`
+	StatusCode    int    `json:"statusCode"`
+	Text          string `json:"text"`
+	SourceURL     string `json:"sourceUrl"`
+	Element       string `json:"element"`
+	Attribute     string `json:"attribute"`
+	// Attempts is the number of times the link was requested before it was
+	// found broken
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"-"`
+}
+
+// SkippedURL records a URL the crawler deliberately did not fetch
+type SkippedURL struct {
+	ID            uint      `json:"-" gorm:"primaryKey"`
+	CrawlResultID string    `json:"-" gorm:"not null;index"`
+	URL           string    `json:"url" gorm:"not null"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `json:"-"`
+}
+
+// SitemapURL is a URL listed in the site's sitemap or linked from a crawled
+// page but missing from the sitemap
+type SitemapURL struct {
+	ID            uint       `json:"-" gorm:"primaryKey"`
+	CrawlResultID string     `json:"-" gorm:"not null;index"`
+	URL           string     `json:"url" gorm:"not null"`
+	Sitemap       string     `json:"sitemap,omitempty"`
+	LastMod       *time.Time `json:"lastmod,omitempty"`
+	Priority      *float64   `json:"priority,omitempty"`
+	InSitemap     bool       `json:"inSitemap"`
+	Linked        bool       `json:"linked"`
+	CreatedAt     time.Time  `json:"-"`
+}
+
+// SitemapReport lists sitemap URLs no page links to and linked pages the
+// sitemap does not list
+type SitemapReport struct {
+	CrawlResultID    string       `json:"crawlResultId"`
+	Sitemaps         []string     `json:"sitemaps"`
+	SitemapURLsCount int          `json:"sitemapUrlsCount"`
+	OrphanedURLs     []SitemapURL `json:"orphanedUrls"`
+	UnlistedURLs     []SitemapURL `json:"unlistedUrls"`
 }
 
 type HeadingCounts struct {
@@ -58,20 +139,53 @@ type HeadingCounts struct {
 	H6 int `json:"h6"`
 }
 
+// SpecialLinkCounts counts links that don't lead to another page and are
+// therefore neither internal nor external
+type SpecialLinkCounts struct {
+	Mailto     int `json:"mailto"`
+	Tel        int `json:"tel"`
+	Javascript int `json:"javascript"`
+	// Fragment counts same-page links such as "#top"
+	Fragment int `json:"fragment"`
+	// Other counts remaining non-HTTP schemes such as ftp: or sms:
+	Other int `json:"other"`
+}
+
+// HostDelay is the current delay between requests to a host a running crawl
+// has contacted
+type HostDelay struct {
+	Host    string `json:"host"`
+	DelayMs int64  `json:"delayMs"`
+	// Throttled is set when the delay was raised because the host responded
+	// slowly or with errors
+	Throttled bool `json:"throttled"`
+}
+
 type CrawlResultResponse struct {
-	ID                  string        `json:"id"`
-	URL                 string        `json:"url"`
-	Title               string        `json:"title"`
-	HTMLVersion         string        `json:"htmlVersion"`
-	HeadingCounts       HeadingCounts `json:"headingCounts"`
-	InternalLinksCount  int           `json:"internalLinksCount"`
-	ExternalLinksCount  int           `json:"externalLinksCount"`
-	BrokenLinksCount    int           `json:"brokenLinksCount"`
-	HasLoginForm        bool          `json:"hasLoginForm"`
-	Status              CrawlStatus   `json:"status"`
-	ErrorMessage        *string       `json:"errorMessage,omitempty"`
-	CrawledAt           time.Time     `json:"crawledAt"`
-	BrokenLinks         []BrokenLink  `json:"brokenLinks"`
+	ID                 string            `json:"id"`
+	URL                string            `json:"url"`
+	Title              string            `json:"title"`
+	ContentType        string            `json:"contentType,omitempty"`
+	HTMLVersion        string            `json:"htmlVersion"`
+	HeadingCounts      HeadingCounts     `json:"headingCounts"`
+	InternalLinksCount int               `json:"internalLinksCount"`
+	ExternalLinksCount int               `json:"externalLinksCount"`
+	SpecialLinkCounts  SpecialLinkCounts `json:"specialLinkCounts"`
+	BrokenLinksCount   int               `json:"brokenLinksCount"`
+	HasLoginForm       bool              `json:"hasLoginForm"`
+	PagesCrawled       int               `json:"pagesCrawled"`
+	Attempts           int               `json:"attempts"`
+	Status             CrawlStatus       `json:"status"`
+	QueuePosition      int               `json:"queuePosition,omitempty"`
+	HostDelays         []HostDelay       `json:"hostDelays,omitempty"`
+	Options            *CrawlOptions     `json:"options,omitempty"`
+	ErrorMessage       *string           `json:"errorMessage,omitempty"`
+	ErrorCategory      string            `json:"errorCategory,omitempty"`
+	CrawledAt          time.Time         `json:"crawledAt"`
+	BrokenLinks        []BrokenLink      `json:"brokenLinks"`
+	Pages              []CrawledPage     `json:"pages,omitempty"`
+	SkippedURLs        []SkippedURL      `json:"skippedUrls,omitempty"`
+	RedirectChains     []RedirectChain   `json:"redirectChains,omitempty"`
 }
 
 // BeforeCreate will set a UUID rather than numeric ID.
@@ -110,18 +224,29 @@ func (cr *CrawlResult) SetHeadingCounts(counts HeadingCounts) {
 // ToResponse converts CrawlResult to CrawlResultResponse for JSON output
 func (cr *CrawlResult) ToResponse() CrawlResultResponse {
 	return CrawlResultResponse{
-		ID:                  cr.ID,
-		URL:                 cr.URL,
-		Title:               cr.Title,
-		HTMLVersion:         cr.HTMLVersion,
-		HeadingCounts:       cr.GetHeadingCounts(),
-		InternalLinksCount:  cr.InternalLinksCount,
-		ExternalLinksCount:  cr.ExternalLinksCount,
-		BrokenLinksCount:    cr.BrokenLinksCount,
-		HasLoginForm:        cr.HasLoginForm,
-		Status:              cr.Status,
-		ErrorMessage:        cr.ErrorMessage,
-		CrawledAt:           cr.CrawledAt,
-		BrokenLinks:         cr.BrokenLinks,
+		ID:                 cr.ID,
+		URL:                cr.URL,
+		Title:              cr.Title,
+		ContentType:        cr.ContentType,
+		HTMLVersion:        cr.HTMLVersion,
+		HeadingCounts:      cr.GetHeadingCounts(),
+		InternalLinksCount: cr.InternalLinksCount,
+		ExternalLinksCount: cr.ExternalLinksCount,
+		SpecialLinkCounts:  cr.SpecialLinkCounts,
+		BrokenLinksCount:   cr.BrokenLinksCount,
+		HasLoginForm:       cr.HasLoginForm,
+		PagesCrawled:       cr.PagesCrawled,
+		Attempts:           cr.Attempts,
+		Status:             cr.Status,
+		QueuePosition:      cr.QueuePosition,
+		HostDelays:         cr.HostDelays,
+		Options:            cr.Options.Redacted(),
+		ErrorMessage:       cr.ErrorMessage,
+		ErrorCategory:      cr.ErrorCategory,
+		CrawledAt:          cr.CrawledAt,
+		BrokenLinks:        cr.BrokenLinks,
+		Pages:              cr.Pages,
+		SkippedURLs:        cr.SkippedURLs,
+		RedirectChains:     cr.RedirectChains,
 	}
 }
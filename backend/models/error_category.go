@@ -0,0 +1,67 @@
+package models
+
+// Categories of the errors a crawl or a page can fail with
+const (
+	ErrorDNS               = "dns"
+	ErrorConnectionRefused = "connection_refused"
+	ErrorConnectionReset   = "connection_reset"
+	ErrorTLS               = "tls"
+	ErrorTimeout           = "timeout"
+	ErrorHTTP4xx           = "http_4xx"
+	ErrorHTTP5xx           = "http_5xx"
+	// ErrorHTTPOther is any other status but 200, e.g. 204 or 304
+	ErrorHTTPOther        = "http_other"
+	ErrorRedirect         = "redirect"
+	ErrorContentType      = "content_type_rejected"
+	ErrorBodyTooLarge     = "body_too_large"
+	ErrorParse            = "parse"
+	ErrorRobotsDisallowed = "robots_disallowed"
+	ErrorAuth             = "auth"
+	ErrorCancelled        = "cancelled"
+	ErrorInterrupted      = "interrupted"
+	ErrorInvalidOptions   = "invalid_options"
+	ErrorOther            = "other"
+)
+
+// ErrorCategories lists every error category
+var ErrorCategories = []string{
+	ErrorDNS,
+	ErrorConnectionRefused,
+	ErrorConnectionReset,
+	ErrorTLS,
+	ErrorTimeout,
+	ErrorHTTP4xx,
+	ErrorHTTP5xx,
+	ErrorHTTPOther,
+	ErrorRedirect,
+	ErrorContentType,
+	ErrorBodyTooLarge,
+	ErrorParse,
+	ErrorRobotsDisallowed,
+	ErrorAuth,
+	ErrorCancelled,
+	ErrorInterrupted,
+	ErrorInvalidOptions,
+	ErrorOther,
+}
+
+// IsErrorCategory reports whether category is one of ErrorCategories
+func IsErrorCategory(category string) bool {
+	for _, known := range ErrorCategories {
+		if category == known {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPErrorCategory returns the category of an error status code
+func HTTPErrorCategory(statusCode int) string {
+	switch {
+	case statusCode >= 500:
+		return ErrorHTTP5xx
+	case statusCode >= 400:
+		return ErrorHTTP4xx
+	}
+	return ErrorHTTPOther
+}
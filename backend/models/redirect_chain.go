@@ -0,0 +1,51 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedirectChain records the redirects followed to fetch a page
+type RedirectChain struct {
+	ID            uint          `json:"-" gorm:"primaryKey"`
+	CrawlResultID string        `json:"-" gorm:"not null;index"`
+	StartURL      string        `json:"startUrl" gorm:"not null"`
+	FinalURL      string        `json:"finalUrl"`
+	FinalStatus   int           `json:"finalStatus"`
+	Hops          []RedirectHop `json:"hops" gorm:"type:json;serializer:json"`
+	Issues        []string      `json:"issues" gorm:"type:json;serializer:json"`
+	CreatedAt     time.Time     `json:"-"`
+}
+
+// RedirectHop is a single redirect response
+type RedirectHop struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	Location   string `json:"location"`
+	LatencyMs  int64  `json:"latencyMs"`
+}
+
+// AddIssue flags the chain with an issue, once
+func (rc *RedirectChain) AddIssue(issue string) {
+	for _, existing := range rc.Issues {
+		if existing == issue {
+			return
+		}
+	}
+	rc.Issues = append(rc.Issues, issue)
+}
+
+// Describe renders the chain as "url (301) -> url (302) -> url"
+func (rc *RedirectChain) Describe() string {
+	parts := make([]string, 0, len(rc.Hops)+1)
+	for _, hop := range rc.Hops {
+		parts = append(parts, hop.URL+" ("+strconv.Itoa(hop.StatusCode)+")")
+	}
+	if rc.FinalURL != "" {
+		parts = append(parts, rc.FinalURL)
+	} else if len(rc.Hops) > 0 {
+		parts = append(parts, rc.Hops[len(rc.Hops)-1].Location)
+	}
+	return strings.Join(parts, " -> ")
+}
@@ -0,0 +1,17 @@
+package models
+
+// ScopePreview lists the URLs found on a seed page split by whether a crawl
+// with the submitted scope rules would follow or check them
+type ScopePreview struct {
+	URL        string          `json:"url"`
+	InScope    []ScopeDecision `json:"inScope"`
+	OutOfScope []ScopeDecision `json:"outOfScope"`
+}
+
+// ScopeDecision is the scope outcome for a single URL
+type ScopeDecision struct {
+	URL      string `json:"url"`
+	Element  string `json:"element"`
+	Internal bool   `json:"internal"`
+	Reason   string `json:"reason,omitempty"`
+}
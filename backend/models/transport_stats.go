@@ -0,0 +1,16 @@
+package models
+
+// TransportStats reports how the crawler's shared HTTP transport served
+// requests since the server started
+type TransportStats struct {
+	Requests          int64 `json:"requests"`
+	ReusedConnections int64 `json:"reusedConnections"`
+	NewConnections    int64 `json:"newConnections"`
+	DialErrors        int64 `json:"dialErrors"`
+	// ConnectionReuseRate is the share of connections that came from the
+	// idle pool rather than being dialed
+	ConnectionReuseRate float64 `json:"connectionReuseRate"`
+	DNSCacheHits        int64   `json:"dnsCacheHits"`
+	DNSCacheMisses      int64   `json:"dnsCacheMisses"`
+	DNSCacheEntries     int     `json:"dnsCacheEntries"`
+}
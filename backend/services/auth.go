@@ -0,0 +1,535 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+
+	"webcrawler/config"
+	"webcrawler/models"
+)
+
+// credentialCipher encrypts the auth options of crawls for storage
+type credentialCipher struct {
+	aead cipher.AEAD
+}
+
+// errNoCredentialsKey rejects auth options when no credentials key is set
+var errNoCredentialsKey = errors.New("auth options require CREDENTIALS_KEY to be configured on the server")
+
+// newCredentialCipher derives the AES-256 key from the configured credentials
+// key. It returns nil when no key is set, and crawls can't use auth options.
+func newCredentialCipher(cfg *config.Config) *credentialCipher {
+	if cfg.CredentialsKey == "" {
+		return nil
+	}
+	key := sha256.Sum256([]byte(cfg.CredentialsKey))
+	// A 32-byte key is always valid
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return &credentialCipher{aead: aead}
+}
+
+// AcceptsCredentials reports whether crawls may use auth options
+func (cs *CrawlerService) AcceptsCredentials() bool {
+	return cs.credentials != nil
+}
+
+// seal encrypts auth options, returning the nonce and ciphertext as base64
+func (c *credentialCipher) seal(auth *models.AuthOptions) (string, error) {
+	plaintext, err := json.Marshal(auth)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode credentials: %v", err)
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to encrypt credentials: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// open decrypts auth options sealed by seal
+func (c *credentialCipher) open(sealed string) (*models.AuthOptions, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return nil, errors.New("stored credentials are corrupt")
+	}
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt stored credentials, the credentials key may have changed")
+	}
+	var auth models.AuthOptions
+	if err := json.Unmarshal(plaintext, &auth); err != nil {
+		return nil, fmt.Errorf("failed to decode stored credentials: %v", err)
+	}
+	return &auth, nil
+}
+
+// sealOptions returns the options to store for a crawl and its encrypted
+// credentials. The stored options keep a redacted copy of the auth options.
+func (cs *CrawlerService) sealOptions(options *models.CrawlOptions) (*models.CrawlOptions, string, error) {
+	if options == nil || options.Auth == nil {
+		return options, "", nil
+	}
+	if cs.credentials == nil {
+		return nil, "", errNoCredentialsKey
+	}
+	sealed, err := cs.credentials.seal(options.Auth)
+	if err != nil {
+		return nil, "", err
+	}
+	stored := *options
+	stored.Auth = options.Auth.Redacted()
+	return &stored, sealed, nil
+}
+
+// crawlAuth returns the auth options of a crawl, decrypting the stored
+// credentials
+func (cs *CrawlerService) crawlAuth(crawlResult *models.CrawlResult) (*models.AuthOptions, error) {
+	if crawlResult.Credentials != "" {
+		if cs.credentials == nil {
+			return nil, errNoCredentialsKey
+		}
+		return cs.credentials.open(crawlResult.Credentials)
+	}
+	if crawlResult.Options != nil {
+		return crawlResult.Options.Auth, nil
+	}
+	return nil, nil
+}
+
+// authorize adds the crawl's credentials to a request to the crawled site.
+// Form logins authenticate through the session cookies instead.
+func (t *sessionTransport) authorize(req *http.Request) {
+	auth := t.settings.auth
+	if auth == nil {
+		return
+	}
+	switch auth.Type {
+	case models.AuthBasic:
+		req.SetBasicAuth(auth.Username, auth.Password)
+	case models.AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+	case models.AuthDigest:
+		t.digest.authorize(req)
+	}
+}
+
+// digestChallenge is the last Digest challenge a host sent
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	// count is the number of requests sent with the nonce
+	count int
+}
+
+// digestAuth answers the HTTP Digest challenges of the crawled site's hosts
+type digestAuth struct {
+	mutex      sync.Mutex
+	username   string
+	password   string
+	challenges map[string]*digestChallenge
+}
+
+func newDigestAuth(username, password string) *digestAuth {
+	return &digestAuth{username: username, password: password, challenges: make(map[string]*digestChallenge)}
+}
+
+// challenge remembers the Digest challenge of a 401 response and reports
+// whether it had one the crawler can answer
+func (d *digestAuth) challenge(resp *http.Response) bool {
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		params := parseAuthParams(rest)
+		if params["nonce"] == "" || digestHash(params["algorithm"]) == nil {
+			continue
+		}
+		c := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+		}
+		for _, qop := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		d.mutex.Lock()
+		d.challenges[resp.Request.URL.Host] = c
+		d.mutex.Unlock()
+		return true
+	}
+	return false
+}
+
+// authorize answers the last challenge of the request's host, if any
+func (d *digestAuth) authorize(req *http.Request) {
+	d.mutex.Lock()
+	c, ok := d.challenges[req.URL.Host]
+	if !ok {
+		d.mutex.Unlock()
+		return
+	}
+	c.count++
+	challenge := *c
+	d.mutex.Unlock()
+
+	h := func(parts ...string) string {
+		hash := digestHash(challenge.algorithm)
+		hash.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+	nonceCount := fmt.Sprintf("%08x", challenge.count)
+	clientNonce := make([]byte, 16)
+	rand.Read(clientNonce)
+	cnonce := hex.EncodeToString(clientNonce)
+
+	uri := req.URL.RequestURI()
+	ha1 := h(d.username, challenge.realm, d.password)
+	if strings.HasSuffix(strings.ToLower(challenge.algorithm), "-sess") {
+		ha1 = h(ha1, challenge.nonce, cnonce)
+	}
+	ha2 := h(req.Method, uri)
+
+	fields := []string{
+		fmt.Sprintf("username=%q", d.username),
+		fmt.Sprintf("realm=%q", challenge.realm),
+		fmt.Sprintf("nonce=%q", challenge.nonce),
+		fmt.Sprintf("uri=%q", uri),
+	}
+	if challenge.qop != "" {
+		response := h(ha1, challenge.nonce, nonceCount, cnonce, challenge.qop, ha2)
+		fields = append(fields, fmt.Sprintf("response=%q", response), "qop="+challenge.qop,
+			"nc="+nonceCount, fmt.Sprintf("cnonce=%q", cnonce))
+	} else {
+		fields = append(fields, fmt.Sprintf("response=%q", h(ha1, challenge.nonce, ha2)))
+	}
+	if challenge.algorithm != "" {
+		fields = append(fields, "algorithm="+challenge.algorithm)
+	}
+	if challenge.opaque != "" {
+		fields = append(fields, fmt.Sprintf("opaque=%q", challenge.opaque))
+	}
+	req.Header.Set("Authorization", "Digest "+strings.Join(fields, ", "))
+}
+
+// digestHash returns the hash of a Digest algorithm, or nil when it isn't
+// supported
+func digestHash(algorithm string) hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return md5.New()
+	case "SHA-256", "SHA-256-SESS":
+		return sha256.New()
+	}
+	return nil
+}
+
+// parseAuthParams parses the comma-separated key=value parameters of a
+// WWW-Authenticate challenge. Values may be quoted.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+}
+
+// login signs in to the crawled site when the crawl uses a form login. The
+// session cookies the site sets stay in the crawl's cookie jar.
+func (cs *CrawlerService) login(ctx context.Context, session *crawlSession) error {
+	auth := session.settings.auth
+	if auth == nil || auth.Type != models.AuthForm {
+		return nil
+	}
+	form := auth.Form
+	if form == nil {
+		form = &models.FormLoginOptions{}
+	}
+
+	action, method := form.Action, http.MethodPost
+	values := url.Values{}
+	usernameField, passwordField := form.UsernameField, form.PasswordField
+	if action == "" {
+		loginURL := form.LoginURL
+		if loginURL == "" {
+			loginURL = session.seedURL
+		}
+		resp, chain, _, err := cs.fetchPage(ctx, loginURL, session)
+		if err != nil {
+			return categorize(errorCategoryOr(err, models.ErrorAuth), fmt.Errorf("failed to fetch login page: %w", err))
+		}
+		doc, _, err := parseHTMLResponse(resp, session.settings.maxBodySize)
+		resp.Body.Close()
+		if err != nil {
+			return categorize(models.ErrorAuth, fmt.Errorf("failed to read login page: %w", err))
+		}
+		loginForm := cs.findLoginForm(doc)
+		if loginForm == nil {
+			return categorize(models.ErrorAuth, fmt.Errorf("no login form found on %s", chain.FinalURL))
+		}
+
+		var detectedUsername, detectedPassword string
+		values, detectedUsername, detectedPassword = loginFormValues(loginForm)
+		if usernameField == "" {
+			usernameField = detectedUsername
+		}
+		if passwordField == "" {
+			passwordField = detectedPassword
+		}
+		if usernameField == "" || passwordField == "" {
+			return categorize(models.ErrorAuth, fmt.Errorf("could not detect the username and password inputs of the login form on %s", chain.FinalURL))
+		}
+		action = resolveLink(attributeValue(loginForm, "action"), chain.FinalURL)
+		if strings.EqualFold(attributeValue(loginForm, "method"), http.MethodGet) {
+			method = http.MethodGet
+		}
+	}
+	for name, value := range form.Fields {
+		values.Set(name, value)
+	}
+	values.Set(usernameField, auth.Username)
+	values.Set(passwordField, auth.Password)
+
+	var req *http.Request
+	var err error
+	if method == http.MethodGet {
+		target, parseErr := url.Parse(action)
+		if parseErr != nil {
+			return categorize(models.ErrorAuth, fmt.Errorf("invalid login form action: %v", parseErr))
+		}
+		target.RawQuery = values.Encode()
+		req, err = http.NewRequestWithContext(ctx, method, target.String(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, action, strings.NewReader(values.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return categorize(models.ErrorAuth, fmt.Errorf("failed to create login request: %v", withoutURL(err)))
+	}
+
+	// Logins are not retried, posting credentials twice may lock the account
+	resp, err := session.client.Do(req)
+	if err != nil {
+		return categorize(errorCategoryOr(err, models.ErrorAuth), fmt.Errorf("login request failed: %w", withoutURL(err)))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return categorize(models.ErrorAuth, fmt.Errorf("login failed with HTTP %d", resp.StatusCode))
+	}
+	// Sites answer rejected credentials with the login form again
+	if doc, _, err := parseHTMLResponse(resp, session.settings.maxBodySize); err == nil && cs.findLoginForm(doc) != nil {
+		return categorize(models.ErrorAuth, errors.New("login failed, the site still shows a login form"))
+	}
+	return nil
+}
+
+// withoutURL strips the request URL from a request error. The URL of a GET
+// login holds the credentials and errors end up in the crawl result.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// errorCategoryOr returns the category of a request error, or fallback when
+// it isn't a network failure
+func errorCategoryOr(err error, fallback string) string {
+	if category := errorCategory(err); category != models.ErrorOther {
+		return category
+	}
+	return fallback
+}
+
+// findLoginForm returns the first login form of a page, or the first form
+// with a password input
+func (cs *CrawlerService) findLoginForm(doc *html.Node) *html.Node {
+	var forms []*html.Node
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "form" {
+			forms = append(forms, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(doc)
+
+	for _, form := range forms {
+		if cs.isLoginForm(form) {
+			return form
+		}
+	}
+	for _, form := range forms {
+		hasPassword, hasUsername := false, false
+		cs.checkLoginFormFields(form, &hasPassword, &hasUsername)
+		if hasPassword {
+			return form
+		}
+	}
+	return nil
+}
+
+// loginFormValues collects the values a browser would submit with a form,
+// such as hidden CSRF tokens, and detects its username and password inputs
+func loginFormValues(form *html.Node) (values url.Values, usernameField, passwordField string) {
+	values = url.Values{}
+	var firstText string
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && attributeValue(n, "name") != "" && !hasAttribute(n, "disabled") {
+			name := attributeValue(n, "name")
+			switch n.Data {
+			case "input":
+				inputType := strings.ToLower(attributeValue(n, "type"))
+				switch inputType {
+				case "submit", "button", "image", "reset", "file":
+				case "password":
+					if passwordField == "" {
+						passwordField = name
+					}
+				case "checkbox", "radio":
+					if hasAttribute(n, "checked") {
+						value := attributeValue(n, "value")
+						if value == "" {
+							value = "on"
+						}
+						values.Add(name, value)
+					}
+				default:
+					if inputType == "" || inputType == "text" || inputType == "email" {
+						lower := strings.ToLower(name + " " + attributeValue(n, "id"))
+						if usernameField == "" && (strings.Contains(lower, "user") || strings.Contains(lower, "email") || strings.Contains(lower, "login")) {
+							usernameField = name
+						}
+						if firstText == "" {
+							firstText = name
+						}
+					}
+					values.Add(name, attributeValue(n, "value"))
+				}
+			case "textarea":
+				values.Add(name, textContent(n))
+			case "select":
+				values.Add(name, selectedOption(n))
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(form)
+
+	if usernameField == "" {
+		usernameField = firstText
+	}
+	return values, usernameField, passwordField
+}
+
+// hasAttribute reports whether an element has an attribute, with or without
+// a value
+func hasAttribute(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// textContent returns the text inside a node
+func textContent(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	return text.String()
+}
+
+// selectedOption returns the value of a select's selected option, or of its
+// first option
+func selectedOption(n *html.Node) string {
+	var first, selected *html.Node
+	var collect func(*html.Node)
+	collect = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "option" {
+			if first == nil {
+				first = node
+			}
+			if selected == nil && hasAttribute(node, "selected") {
+				selected = node
+			}
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	option := selected
+	if option == nil {
+		option = first
+	}
+	if option == nil {
+		return ""
+	}
+	if hasAttribute(option, "value") {
+		return attributeValue(option, "value")
+	}
+	return strings.TrimSpace(textContent(option))
+}
@@ -11,25 +11,28 @@ type AuthService struct {
 	jwtSecret []byte
 }
 
+// RoleAdmin is the role of users allowed to use the admin routes
+const RoleAdmin = "admin"
+
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewAuthService() *AuthService {
-	// In production, use a secure secret from environment variables
-	secret := []byte("your-secret-key")
+func NewAuthService(jwtSecret string) *AuthService {
 	return &AuthService{
-		jwtSecret: secret,
+		jwtSecret: []byte(jwtSecret),
 	}
 }
 
-// GenerateToken creates a new JWT token for the user
-func (s *AuthService) GenerateToken(username string) (string, time.Time, error) {
+// GenerateToken creates a new JWT token for the user with the given role
+func (s *AuthService) GenerateToken(username, role string) (string, time.Time, error) {
 	expirationTime := time.Now().Add(24 * time.Hour) // Token expires in 24 hours
 
 	claims := &Claims{
 		Username: username,
+		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -46,8 +49,8 @@ func (s *AuthService) GenerateToken(username string) (string, time.Time, error)
 	return tokenString, expirationTime, nil
 }
 
-// ValidateToken validates a JWT token and returns the username
-func (s *AuthService) ValidateToken(tokenString string) (string, error) {
+// ValidateToken validates a JWT token and returns its claims
+func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -55,12 +58,12 @@ func (s *AuthService) ValidateToken(tokenString string) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claims.Username, nil
+	return claims, nil
 }
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"webcrawler/config"
+	"webcrawler/models"
+)
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want map[string]string
+	}{
+		{"unquoted", `nonce=abc, algorithm=MD5`, map[string]string{"nonce": "abc", "algorithm": "MD5"}},
+		{"quoted", `realm="example", nonce="abc"`, map[string]string{"realm": "example", "nonce": "abc"}},
+		{"comma in quotes", `realm="a, b", qop="auth,auth-int"`, map[string]string{"realm": "a, b", "qop": "auth,auth-int"}},
+		{"escaped quote", `realm="say \"hi\"", nonce=n`, map[string]string{"realm": `say "hi"`, "nonce": "n"}},
+		{"escaped backslash", `realm="a\\b"`, map[string]string{"realm": `a\b`}},
+		{"empty quoted", `opaque="", nonce=n`, map[string]string{"opaque": "", "nonce": "n"}},
+		{"keys lowercased", `Realm="r", NONCE=n`, map[string]string{"realm": "r", "nonce": "n"}},
+		{"spaces around", `  realm = "r" ,  nonce = n  ,`, map[string]string{"realm": "r", "nonce": "n"}},
+		{"unterminated quote", `realm="open`, map[string]string{"realm": "open"}},
+		{"no params", ``, map[string]string{}},
+		{"token without value", `stale`, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAuthParams(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAuthParams(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestAuthorize(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		newHash   func() hash.Hash
+		sess      bool
+		qop       string
+	}{
+		{"md5 default", `realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+			md5.New, false, "auth"},
+		{"md5 without qop", `realm="r", nonce="n1"`, md5.New, false, ""},
+		{"md5-sess", `realm="r", nonce="n2", qop="auth", algorithm=MD5-sess`, md5.New, true, "auth"},
+		{"sha-256", `realm="r", nonce="n3", qop="auth", algorithm=SHA-256`, sha256.New, false, "auth"},
+		{"sha-256-sess quoted", `realm="r", nonce="n4", qop="auth", algorithm="SHA-256-sess"`, sha256.New, true, "auth"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDigestAuth("Mufasa", "Circle Of Life")
+			req, _ := http.NewRequest(http.MethodGet, "https://host.com/dir/index.html?x=1", nil)
+			resp := &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Header:     http.Header{"Www-Authenticate": {`Basic realm="r"`, "Digest " + tt.challenge}},
+				Request:    req,
+			}
+			if !d.challenge(resp) {
+				t.Fatal("challenge() = false, want true")
+			}
+
+			for count := 1; count <= 2; count++ {
+				retry := req.Clone(req.Context())
+				d.authorize(retry)
+				header := retry.Header.Get("Authorization")
+				scheme, rest, _ := strings.Cut(header, " ")
+				if scheme != "Digest" {
+					t.Fatalf("Authorization = %q, want a Digest answer", header)
+				}
+				got := parseAuthParams(rest)
+				want := parseAuthParams(tt.challenge)
+
+				h := func(parts ...string) string {
+					hash := tt.newHash()
+					hash.Write([]byte(strings.Join(parts, ":")))
+					return hex.EncodeToString(hash.Sum(nil))
+				}
+				ha1 := h("Mufasa", want["realm"], "Circle Of Life")
+				if tt.sess {
+					ha1 = h(ha1, want["nonce"], got["cnonce"])
+				}
+				ha2 := h(http.MethodGet, "/dir/index.html?x=1")
+				expected := h(ha1, want["nonce"], ha2)
+				if tt.qop != "" {
+					expected = h(ha1, want["nonce"], got["nc"], got["cnonce"], tt.qop, ha2)
+					if nc := fmt.Sprintf("%08x", count); got["nc"] != nc {
+						t.Errorf("nc = %q, want %q", got["nc"], nc)
+					}
+					if got["cnonce"] == "" {
+						t.Error("cnonce is missing")
+					}
+				}
+
+				if got["response"] != expected {
+					t.Errorf("response = %q, want %q", got["response"], expected)
+				}
+				if got["username"] != "Mufasa" || got["realm"] != want["realm"] || got["nonce"] != want["nonce"] ||
+					got["uri"] != "/dir/index.html?x=1" || got["qop"] != tt.qop || got["opaque"] != want["opaque"] ||
+					got["algorithm"] != want["algorithm"] {
+					t.Errorf("Authorization params = %v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestDigestChallengeUnsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+	}{
+		{"basic only", []string{`Basic realm="r"`}},
+		{"unknown algorithm", []string{`Digest realm="r", nonce="n", algorithm=SHA-512-256`}},
+		{"no nonce", []string{`Digest realm="r", qop="auth"`}},
+		{"no challenge", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "https://host.com/", nil)
+			resp := &http.Response{Header: http.Header{"Www-Authenticate": tt.headers}, Request: req}
+			d := newDigestAuth("user", "pass")
+			if d.challenge(resp) {
+				t.Error("challenge() = true, want false")
+			}
+			d.authorize(req)
+			if header := req.Header.Get("Authorization"); header != "" {
+				t.Errorf("Authorization = %q, want none", header)
+			}
+		})
+	}
+}
+
+func TestCredentialCipher(t *testing.T) {
+	if newCredentialCipher(&config.Config{}) != nil {
+		t.Fatal("cipher without a credentials key, want none")
+	}
+
+	auth := &models.AuthOptions{Type: models.AuthBasic, Username: "user", Password: "secret"}
+	cipher := newCredentialCipher(&config.Config{CredentialsKey: "0123456789abcdef"})
+	sealed, err := cipher.seal(auth)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if strings.Contains(sealed, "secret") {
+		t.Error("sealed credentials hold the password in clear text")
+	}
+	opened, err := cipher.open(sealed)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !reflect.DeepEqual(opened, auth) {
+		t.Errorf("open = %+v, want %+v", opened, auth)
+	}
+
+	other := newCredentialCipher(&config.Config{CredentialsKey: "fedcba9876543210"})
+	if _, err := other.open(sealed); err == nil {
+		t.Error("open with another key succeeded")
+	}
+	if _, err := cipher.open("not base64!"); err == nil {
+		t.Error("open of corrupt credentials succeeded")
+	}
+}
+
+func TestWithoutURL(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := withoutURL(&url.Error{Op: "Get", URL: "https://site/login?user=u&pass=secret", Err: cause})
+	if err != cause {
+		t.Errorf("withoutURL = %v, want %v", err, cause)
+	}
+	if err := withoutURL(cause); err != cause {
+		t.Errorf("withoutURL = %v, want the error unchanged", err)
+	}
+}
@@ -1,71 +1,127 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
 
+	"webcrawler/config"
 	"webcrawler/models"
 
 	"github.com/google/uuid"
 	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
 	"gorm.io/gorm"
 )
 
 type CrawlerService struct {
-	db                  *gorm.DB
-	maxConcurrentCrawls int
-	crawlTimeout        time.Duration
-	maxDepth            int
-	maxPagesPerDomain   int
-	activeCrawls        map[string]bool
-	mutex               sync.RWMutex
+	db             *gorm.DB
+	runtime        runtimeSettings
+	runtimeMutex   sync.RWMutex
+	workers        int
+	workersStarted bool
+	robots         *robotsCache
+	hosts          *hostScheduler
+	transport      *crawlerTransport
+	credentials    *credentialCipher
+	activeCrawls   map[string]context.CancelCauseFunc
+	crawlHosts     map[string]*requestHosts
+	mutex          sync.RWMutex
+	queueMutex     sync.Mutex
+	wake           chan struct{}
+	orphanPolicy   OrphanPolicy
+	leaseTimeout   time.Duration
+	shuttingDown   bool
+	quit           chan struct{}
+	running        sync.WaitGroup
 }
 
 type CrawlData struct {
-	Title              string
-	HTMLVersion        string
-	HeadingCounts      models.HeadingCounts
-	InternalLinks      []string
-	ExternalLinks      []string
-	BrokenLinks        []models.BrokenLink
-	HasLoginForm       bool
+	StatusCode    int
+	FinalURL      string
+	Redirects     *models.RedirectChain
+	Title         string
+	HTMLVersion   string
+	HeadingCounts models.HeadingCounts
+	// Links are the page's links to HTTP(S) pages before classification
+	Links         []string
+	SpecialLinks  []specialLink
+	InternalLinks []string
+	ExternalLinks []string
+	BrokenLinks   []models.BrokenLink
+	LinkRefs      []LinkRef
+	HasLoginForm  bool
+	// Attempts is the number of times the page's final request was sent
+	Attempts    int
+	ContentType string
 }
 
-func NewCrawlerService(db *gorm.DB) *CrawlerService {
-	return &CrawlerService{
-		db:                  db,
-		maxConcurrentCrawls: 5,
-		crawlTimeout:        30 * time.Second,
-		maxDepth:            3,
-		maxPagesPerDomain:   100,
-		activeCrawls:        make(map[string]bool),
+// LinkRef is a URL referenced by an element of a page, resolved against the
+// page but not normalized
+type LinkRef struct {
+	URL       string
+	Text      string
+	Element   string
+	Attribute string
+}
+
+// NewCrawlerService creates the crawler from the validated server config
+func NewCrawlerService(cfg *config.Config) *CrawlerService {
+	cs := &CrawlerService{
+		db:           cfg.DB,
+		robots:       newRobotsCache(),
+		hosts:        newHostScheduler(),
+		transport:    newCrawlerTransport(cfg),
+		credentials:  newCredentialCipher(cfg),
+		activeCrawls: make(map[string]context.CancelCauseFunc),
+		crawlHosts:   make(map[string]*requestHosts),
+		wake:         make(chan struct{}, 1),
+		orphanPolicy: OrphanPolicy(cfg.OrphanedCrawlPolicy),
+		leaseTimeout: time.Duration(cfg.CrawlLeaseTimeout) * time.Second,
+		quit:         make(chan struct{}),
 	}
+	cs.ApplyConfig(cfg)
+	return cs
 }
 
-// SubmitURL creates a new crawl result entry
-func (cs *CrawlerService) SubmitURL(targetURL string) (*models.CrawlResult, error) {
+// SubmitURL creates a new crawl result entry with optional per-crawl options
+func (cs *CrawlerService) SubmitURL(targetURL string, options *models.CrawlOptions) (*models.CrawlResult, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	// Credentials are only stored encrypted
+	stored, credentials, err := cs.sealOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
 	crawlResult := &models.CrawlResult{
-		ID:        uuid.New().String(),
-		URL:       targetURL,
-		Status:    models.StatusQueued,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:          uuid.New().String(),
+		URL:         targetURL,
+		Options:     stored,
+		Credentials: credentials,
+		Status:      models.StatusQueued,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
 	}
 
 	if err := cs.db.Create(crawlResult).Error; err != nil {
 		return nil, err
 	}
 
+	crawlResult.Options = stored.Redacted()
 	return crawlResult, nil
 }
 
 // GetAllCrawls retrieves crawl results with pagination and filtering
-func (cs *CrawlerService) GetAllCrawls(page, limit int, status, search, sortBy, sortOrder string) ([]models.CrawlResult, int64, error) {
+func (cs *CrawlerService) GetAllCrawls(page, limit int, status, errorCategory, search, sortBy, sortOrder string) ([]models.CrawlResult, int64, error) {
 	var crawls []models.CrawlResult
 	var total int64
 
@@ -76,6 +132,10 @@ func (cs *CrawlerService) GetAllCrawls(page, limit int, status, search, sortBy,
 		query = query.Where("status = ?", status)
 	}
 
+	if errorCategory != "" {
+		query = query.Where("error_category = ?", errorCategory)
+	}
+
 	if search != "" {
 		query = query.Where("url LIKE ? OR title LIKE ?", "%"+search+"%", "%"+search+"%")
 	}
@@ -98,36 +158,55 @@ func (cs *CrawlerService) GetAllCrawls(page, limit int, status, search, sortBy,
 		return nil, 0, err
 	}
 
+	positions := cs.queuePositions()
+	for i := range crawls {
+		crawls[i].QueuePosition = positions[crawls[i].ID]
+		crawls[i].HostDelays = cs.hostDelays(crawls[i].ID)
+		crawls[i].Options = crawls[i].Options.Redacted()
+	}
+
 	return crawls, total, nil
 }
 
 // GetCrawlResult retrieves a specific crawl result
 func (cs *CrawlerService) GetCrawlResult(id string) (*models.CrawlResult, error) {
 	var crawlResult models.CrawlResult
-	if err := cs.db.Preload("BrokenLinks").First(&crawlResult, "id = ?", id).Error; err != nil {
+	if err := cs.db.Preload("BrokenLinks").Preload("Pages").Preload("SkippedURLs").Preload("RedirectChains").First(&crawlResult, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
+	crawlResult.QueuePosition = cs.queuePositions()[crawlResult.ID]
+	crawlResult.HostDelays = cs.hostDelays(crawlResult.ID)
+	crawlResult.Options = crawlResult.Options.Redacted()
 	return &crawlResult, nil
 }
 
-// DeleteCrawl deletes a crawl result
+// DeleteCrawl deletes a crawl result, cancelling it first if it is queued or running
 func (cs *CrawlerService) DeleteCrawl(id string) error {
+	cs.dequeueCrawl(id)
+	cs.cancelCrawl(id)
 	return cs.db.Delete(&models.CrawlResult{}, "id = ?", id).Error
 }
 
 // BulkDelete deletes multiple crawl results
 func (cs *CrawlerService) BulkDelete(ids []string) error {
+	for _, id := range ids {
+		cs.dequeueCrawl(id)
+		cs.cancelCrawl(id)
+	}
 	return cs.db.Delete(&models.CrawlResult{}, "id IN ?", ids).Error
 }
 
-// BulkStart starts crawling for multiple URLs
-func (cs *CrawlerService) BulkStart(ids []string) error {
+// BulkStart queues crawls for multiple URLs and returns the reason for every
+// ID that could not be queued
+func (cs *CrawlerService) BulkStart(ids []string, priority int) map[string]string {
+	failed := make(map[string]string)
 	for _, id := range ids {
-		if err := cs.StartCrawl(id); err != nil {
+		if err := cs.StartCrawl(id, priority); err != nil {
 			log.Printf("Failed to start crawl for ID %s: %v", id, err)
+			failed[id] = err.Error()
 		}
 	}
-	return nil
+	return failed
 }
 
 // GetStats returns crawling statistics
@@ -138,6 +217,7 @@ func (cs *CrawlerService) GetStats() (map[string]interface{}, error) {
 		Queued    int64
 		Running   int64
 		Error     int64
+		Cancelled int64
 	}
 
 	cs.db.Model(&models.CrawlResult{}).Count(&stats.Total)
@@ -145,54 +225,78 @@ func (cs *CrawlerService) GetStats() (map[string]interface{}, error) {
 	cs.db.Model(&models.CrawlResult{}).Where("status = ?", models.StatusQueued).Count(&stats.Queued)
 	cs.db.Model(&models.CrawlResult{}).Where("status = ?", models.StatusRunning).Count(&stats.Running)
 	cs.db.Model(&models.CrawlResult{}).Where("status = ?", models.StatusError).Count(&stats.Error)
+	cs.db.Model(&models.CrawlResult{}).Where("status = ?", models.StatusCancelled).Count(&stats.Cancelled)
+
+	// Break failed crawls down by what went wrong
+	var categories []struct {
+		ErrorCategory string
+		Count         int64
+	}
+	cs.db.Model(&models.CrawlResult{}).Select("error_category, COUNT(*) AS count").
+		Where("status = ?", models.StatusError).Group("error_category").Scan(&categories)
+	errorsByCategory := make(map[string]int64, len(categories))
+	for _, category := range categories {
+		name := category.ErrorCategory
+		// Crawls that failed before errors were categorized
+		if name == "" {
+			name = models.ErrorOther
+		}
+		errorsByCategory[name] += category.Count
+	}
 
 	return map[string]interface{}{
-		"totalCrawls":     stats.Total,
-		"completedCrawls": stats.Completed,
-		"queuedCrawls":    stats.Queued,
-		"runningCrawls":   stats.Running,
-		"errorCrawls":     stats.Error,
+		"totalCrawls":      stats.Total,
+		"completedCrawls":  stats.Completed,
+		"queuedCrawls":     stats.Queued,
+		"runningCrawls":    stats.Running,
+		"errorCrawls":      stats.Error,
+		"cancelledCrawls":  stats.Cancelled,
+		"errorsByCategory": errorsByCategory,
 	}, nil
 }
 
-// StartCrawl starts the crawling process for a specific URL
-func (cs *CrawlerService) StartCrawl(crawlResultID string) error {
-	cs.mutex.Lock()
-	if cs.activeCrawls[crawlResultID] {
-		cs.mutex.Unlock()
-		return fmt.Errorf("crawl already in progress for ID: %s", crawlResultID)
+// StartCrawl queues the crawling process for a specific URL. Queued crawls
+// are picked up by the worker pool, higher priorities first.
+func (cs *CrawlerService) StartCrawl(crawlResultID string, priority int) error {
+	return cs.enqueueCrawl(crawlResultID, priority)
+}
+
+// StopCrawl cancels a queued or running crawl. A running crawl has its
+// in-flight requests aborted and is saved with its partial results; either
+// way the crawl ends up in the cancelled state.
+func (cs *CrawlerService) StopCrawl(crawlResultID string) error {
+	if cs.dequeueCrawl(crawlResultID) {
+		return cs.db.Model(&models.CrawlResult{}).Where("id = ?", crawlResultID).
+			Updates(map[string]interface{}{"status": models.StatusCancelled, "error_category": models.ErrorCancelled}).Error
 	}
-	
-	if len(cs.activeCrawls) >= cs.maxConcurrentCrawls {
-		cs.mutex.Unlock()
-		return fmt.Errorf("maximum concurrent crawls reached")
+	if !cs.cancelCrawl(crawlResultID) {
+		return fmt.Errorf("no crawl queued or in progress for ID: %s", crawlResultID)
 	}
-	
-	cs.activeCrawls[crawlResultID] = true
-	cs.mutex.Unlock()
-
-	// Start crawling in a goroutine
-	go cs.performCrawl(crawlResultID)
-	
 	return nil
 }
 
-// StopCrawl stops the crawling process for a specific URL
-func (cs *CrawlerService) StopCrawl(crawlResultID string) error {
-	cs.mutex.Lock()
-	defer cs.mutex.Unlock()
-	
-	delete(cs.activeCrawls, crawlResultID)
-	
-	// Update status to queued
-	return cs.db.Model(&models.CrawlResult{}).Where("id = ?", crawlResultID).
-		Update("status", models.StatusQueued).Error
+// cancelCrawl cancels the crawl's context and reports whether it was running
+func (cs *CrawlerService) cancelCrawl(crawlResultID string) bool {
+	cs.mutex.RLock()
+	cancel, active := cs.activeCrawls[crawlResultID]
+	cs.mutex.RUnlock()
+
+	if active {
+		cancel(context.Canceled)
+	}
+	return active
 }
 
-func (cs *CrawlerService) performCrawl(crawlResultID string) {
+// performCrawl runs a crawl and stores its outcome. It reports whether the
+// crawl was interrupted by shutdown and checkpointed rather than finished.
+func (cs *CrawlerService) performCrawl(ctx context.Context, crawlResultID string) bool {
 	defer func() {
 		cs.mutex.Lock()
+		if cancel, ok := cs.activeCrawls[crawlResultID]; ok {
+			cancel(nil)
+		}
 		delete(cs.activeCrawls, crawlResultID)
+		delete(cs.crawlHosts, crawlResultID)
 		cs.mutex.Unlock()
 	}()
 
@@ -200,111 +304,617 @@ func (cs *CrawlerService) performCrawl(crawlResultID string) {
 	var crawlResult models.CrawlResult
 	if err := cs.db.First(&crawlResult, "id = ?", crawlResultID).Error; err != nil {
 		log.Printf("Failed to find crawl result: %v", err)
-		return
+		return false
 	}
 
 	// Update status to running
 	crawlResult.Status = models.StatusRunning
-	cs.db.Save(&crawlResult)
+	if !cs.updateCrawlResult(&crawlResult) {
+		return false
+	}
+
+	// Discard pages and broken links left over from a previous run
+	cs.db.Where("crawl_result_id = ?", crawlResult.ID).Delete(&models.CrawledPage{})
+	cs.db.Where("crawl_result_id = ?", crawlResult.ID).Delete(&models.BrokenLink{})
+	cs.db.Where("crawl_result_id = ?", crawlResult.ID).Delete(&models.SkippedURL{})
+	cs.db.Where("crawl_result_id = ?", crawlResult.ID).Delete(&models.SitemapURL{})
+	cs.db.Where("crawl_result_id = ?", crawlResult.ID).Delete(&models.RedirectChain{})
 
 	// Perform the actual crawling
-	crawlData, err := cs.crawlURL(crawlResult.URL)
+	session, err := cs.newCrawlSession(&crawlResult)
+	if err != nil {
+		errMsg := err.Error()
+		crawlResult.Status = models.StatusError
+		crawlResult.ErrorMessage = &errMsg
+		crawlResult.ErrorCategory = models.ErrorInvalidOptions
+		cs.updateCrawlResult(&crawlResult)
+		return false
+	}
+	cs.mutex.Lock()
+	cs.crawlHosts[crawlResult.ID] = session.requestHosts
+	cs.mutex.Unlock()
+	var crawlData *CrawlData
+	err = cs.login(ctx, session)
+	if err == nil {
+		cs.loadSitemaps(ctx, session, crawlResult.URL)
+		crawlData, err = cs.crawlSite(ctx, session)
+	}
+	cs.saveSkippedURLs(session)
+	cs.saveRedirects(session)
+	if errors.Is(err, context.Canceled) || (err != nil && ctx.Err() != nil) {
+		// Crawls interrupted by shutdown are checkpointed and will be requeued
+		if context.Cause(ctx) == errShuttingDown {
+			log.Printf("Crawl checkpointed for %s after %d pages", crawlResult.URL, len(session.pages))
+			return cs.saveCrawlResults(&crawlResult, session, crawlData, models.StatusQueued)
+		}
+		log.Printf("Crawl cancelled for %s after %d pages", crawlResult.URL, len(session.pages))
+		cs.saveCrawlResults(&crawlResult, session, crawlData, models.StatusCancelled)
+		return false
+	}
 	if err != nil {
 		log.Printf("Crawl failed for %s: %v", crawlResult.URL, err)
-		
+
 		// Update with error
 		errMsg := err.Error()
 		crawlResult.Status = models.StatusError
 		crawlResult.ErrorMessage = &errMsg
-		cs.db.Save(&crawlResult)
-		return
+		crawlResult.ErrorCategory = errorCategory(err)
+		crawlResult.Attempts = session.seedAttempts
+		cs.updateCrawlResult(&crawlResult)
+		return false
+	}
+
+	if cs.saveCrawlResults(&crawlResult, session, crawlData, models.StatusCompleted) {
+		log.Printf("Crawl completed successfully for %s (%d pages)", crawlResult.URL, len(session.pages))
+	}
+	return false
+}
+
+// updateCrawlResult stores all fields of a crawl result and reports whether it
+// still exists. Unlike Save it never inserts, so a crawl deleted while it was
+// running stays deleted.
+func (cs *CrawlerService) updateCrawlResult(crawlResult *models.CrawlResult) bool {
+	result := cs.db.Select("*").Updates(crawlResult)
+	if result.Error != nil {
+		log.Printf("Failed to save crawl result: %v", result.Error)
+		return false
+	}
+	if result.RowsAffected > 0 {
+		return true
 	}
+	// MySQL doesn't count rows the update left unchanged
+	var count int64
+	cs.db.Model(&models.CrawlResult{}).Where("id = ?", crawlResult.ID).Count(&count)
+	return count > 0
+}
 
-	// Update crawl result with data
-	crawlResult.Title = crawlData.Title
-	crawlResult.HTMLVersion = crawlData.HTMLVersion
-	crawlResult.SetHeadingCounts(crawlData.HeadingCounts)
-	crawlResult.InternalLinksCount = len(crawlData.InternalLinks)
-	crawlResult.ExternalLinksCount = len(crawlData.ExternalLinks)
-	crawlResult.BrokenLinksCount = len(crawlData.BrokenLinks)
-	crawlResult.HasLoginForm = crawlData.HasLoginForm
-	crawlResult.Status = models.StatusCompleted
+// saveCrawlResults stores the seed page data, the site-wide totals and the
+// visited pages, broken links and sitemap comparison of a session. crawlData
+// is nil when the crawl was cancelled before the seed page was processed.
+func (cs *CrawlerService) saveCrawlResults(crawlResult *models.CrawlResult, session *crawlSession, crawlData *CrawlData, status models.CrawlStatus) bool {
+	if crawlData != nil {
+		crawlResult.Title = crawlData.Title
+		crawlResult.ContentType = crawlData.ContentType
+		crawlResult.HTMLVersion = crawlData.HTMLVersion
+		crawlResult.SetHeadingCounts(crawlData.HeadingCounts)
+	}
+	crawlResult.InternalLinksCount = len(session.internalLinks)
+	crawlResult.ExternalLinksCount = len(session.externalLinks)
+	specialLinks := make([]specialLink, 0, len(session.specialLinks))
+	for link := range session.specialLinks {
+		specialLinks = append(specialLinks, link)
+	}
+	crawlResult.SpecialLinkCounts = countSpecialLinks(specialLinks)
+	crawlResult.BrokenLinksCount = len(session.brokenChecks)
+	crawlResult.HasLoginForm = session.hasLoginForm
+	crawlResult.PagesCrawled = len(session.pages)
+	crawlResult.Attempts = session.seedAttempts
+	crawlResult.Status = status
+	crawlResult.ErrorMessage = nil
+	crawlResult.ErrorCategory = ""
+	if status == models.StatusCancelled {
+		crawlResult.ErrorCategory = models.ErrorCancelled
+	}
 	crawlResult.CrawledAt = time.Now()
 
 	// Save the crawl result
-	if err := cs.db.Save(&crawlResult).Error; err != nil {
-		log.Printf("Failed to save crawl result: %v", err)
-		return
+	if !cs.updateCrawlResult(crawlResult) {
+		return false
+	}
+
+	// Save visited pages
+	for _, page := range session.pages {
+		page.CrawlResultID = crawlResult.ID
+		cs.db.Create(&page)
 	}
 
 	// Save broken links
-	for _, brokenLink := range crawlData.BrokenLinks {
+	for _, brokenLink := range session.brokenLinks {
 		brokenLink.CrawlResultID = crawlResult.ID
 		cs.db.Create(&brokenLink)
 	}
 
-	log.Printf("Crawl completed successfully for %s", crawlResult.URL)
+	// Save the sitemap comparison
+	for _, sitemapURL := range session.sitemapResults() {
+		sitemapURL.CrawlResultID = crawlResult.ID
+		cs.db.Create(&sitemapURL)
+	}
+
+	return true
 }
 
-func (cs *CrawlerService) crawlURL(targetURL string) (*CrawlData, error) {
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: cs.crawlTimeout,
+// saveRedirects stores the redirect chains followed during the crawl
+func (cs *CrawlerService) saveRedirects(session *crawlSession) {
+	for _, chain := range session.redirects {
+		chain.CrawlResultID = session.crawlResultID
+		cs.db.Create(&chain)
 	}
+}
 
-	// Fetch the webpage
-	resp, err := client.Get(targetURL)
+// saveSkippedURLs stores the URLs the crawler decided not to fetch
+func (cs *CrawlerService) saveSkippedURLs(session *crawlSession) {
+	for _, skipped := range session.skipped {
+		skipped.CrawlResultID = session.crawlResultID
+		cs.db.Create(&skipped)
+	}
+}
+
+// crawlSession holds the state of a single site crawl
+type crawlSession struct {
+	crawlResultID string
+	seedURL       string
+	client        *http.Client
+	// robotsClient fetches robots.txt without the crawl's credentials,
+	// cookies, headers or proxy, as the rules are shared by every crawl
+	robotsClient  *http.Client
+	frontier      []frontierEntry
+	sitemapQueue  []string
+	visited       map[string]bool
+	crawled       map[string]bool
+	pagesPerHost  map[string]int
+	checkedLinks  map[string]bool
+	brokenChecks  map[string]linkCheckResult
+	internalLinks map[string]string
+	externalLinks map[string]bool
+	specialLinks  map[specialLink]bool
+	hasLoginForm  bool
+	pages         []models.CrawledPage
+	brokenLinks   []models.BrokenLink
+	skipped       []models.SkippedURL
+	skippedURLs   map[string]bool
+	sitemaps      []string
+	sitemapURLs   map[string]*models.SitemapURL
+	sitemapOrder  []string
+	linkChecker   *linkChecker
+	normalizer    *urlNormalizer
+	classifier    *linkClassifier
+	scope         *scopeRules
+	settings      *crawlSettings
+	requestHosts  *requestHosts
+	// seedAttempts is the number of times the seed page was requested
+	seedAttempts int
+	redirects    []models.RedirectChain
+}
+
+// frontierEntry is a page waiting to be crawled
+type frontierEntry struct {
+	URL         string
+	Depth       int
+	FromSitemap bool
+	// External pages are fetched but their links are not followed
+	External bool
+}
+
+func (cs *CrawlerService) newCrawlSession(crawlResult *models.CrawlResult) (*crawlSession, error) {
+	options := crawlResult.Options
+	if options == nil {
+		options = &models.CrawlOptions{}
+	}
+	scope, err := newScopeRules(options.Scope)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch URL: %v", err)
+		return nil, err
+	}
+	settings, err := cs.resolveSettings(options)
+	if err != nil {
+		return nil, err
+	}
+	if settings.auth, err = cs.crawlAuth(crawlResult); err != nil {
+		return nil, err
+	}
+	var digest *digestAuth
+	if auth := settings.auth; auth != nil {
+		if auth.Type == models.AuthDigest {
+			digest = newDigestAuth(auth.Username, auth.Password)
+		}
+		// Following a logout link would end the session of a form login
+		scope.keepSession = auth.Type == models.AuthForm
+	}
+	// Cookies the crawled sites set are kept for the rest of the crawl
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cookie jar: %v", err)
+	}
+	classifier := newLinkClassifier(options.Classification, crawlResult.URL)
+	requestHosts := newRequestHosts()
+	robotsClient, err := cs.newRobotsClient(requestHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &crawlSession{
+		crawlResultID: crawlResult.ID,
+		seedURL:       crawlResult.URL,
+		// The request timeout is applied by the transport
+		client: &http.Client{
+			Jar: jar,
+			Transport: &sessionTransport{
+				base:      cs.transport,
+				settings:  settings,
+				scheduler: cs.hosts,
+				hosts:     requestHosts,
+				digest:    digest,
+				toSite: func(req *http.Request) bool {
+					return classifier.isInternal(req.URL)
+				},
+			},
+		},
+		robotsClient:  robotsClient,
+		visited:       make(map[string]bool),
+		crawled:       make(map[string]bool),
+		pagesPerHost:  make(map[string]int),
+		checkedLinks:  make(map[string]bool),
+		brokenChecks:  make(map[string]linkCheckResult),
+		internalLinks: make(map[string]string),
+		externalLinks: make(map[string]bool),
+		specialLinks:  make(map[specialLink]bool),
+		skippedURLs:   make(map[string]bool),
+		sitemapURLs:   make(map[string]*models.SitemapURL),
+		linkChecker:   newLinkChecker(options.LinkCheck),
+		normalizer:    newURLNormalizer(options.Normalization),
+		classifier:    classifier,
+		scope:         scope,
+		settings:      settings,
+		requestHosts:  requestHosts,
+	}
+	session.enqueue(crawlResult.URL, 0)
+	return session, nil
+}
+
+// enqueue adds a page to the frontier unless it has already been seen
+func (s *crawlSession) enqueue(pageURL string, depth int) {
+	s.push(frontierEntry{URL: pageURL, Depth: depth})
+}
+
+// enqueueSitemapURL holds a sitemap entry back until the pages reachable by
+// links have been crawled, so that the sitemap only uses the budget they leave
+func (s *crawlSession) enqueueSitemapURL(pageURL string) {
+	s.sitemapQueue = append(s.sitemapQueue, pageURL)
+}
+
+// releaseSitemapURLs adds the held sitemap entries to the frontier as extra
+// seeds and reports whether the frontier has pages left
+func (s *crawlSession) releaseSitemapURLs() bool {
+	for _, pageURL := range s.sitemapQueue {
+		s.push(frontierEntry{URL: pageURL, FromSitemap: true})
+	}
+	s.sitemapQueue = nil
+	return len(s.frontier) > 0
+}
+
+// enqueueExternal adds an external page to the frontier
+func (s *crawlSession) enqueueExternal(pageURL string, depth int) {
+	s.push(frontierEntry{URL: pageURL, Depth: depth, External: true})
+}
+
+// push adds an entry to the frontier, normalized, unless its URL has already
+// been seen
+func (s *crawlSession) push(entry frontierEntry) {
+	key := s.normalizer.Key(entry.URL)
+	if s.visited[key] {
+		return
+	}
+	s.visited[key] = true
+	entry.URL = s.normalizer.Normalize(entry.URL)
+	s.frontier = append(s.frontier, entry)
+}
+
+// recordRedirects keeps chains that had at least one redirect
+func (s *crawlSession) recordRedirects(chain *models.RedirectChain) {
+	if chain != nil && len(chain.Hops) > 0 {
+		s.redirects = append(s.redirects, *chain)
+	}
+}
+
+// skip records a URL that was not fetched and why, once per URL
+func (s *crawlSession) skip(skippedURL, reason string) {
+	if s.skippedURLs[skippedURL] {
+		return
+	}
+	s.skippedURLs[skippedURL] = true
+	s.skipped = append(s.skipped, models.SkippedURL{URL: skippedURL, Reason: reason})
+}
+
+// isSeed reports whether the entry is the URL the crawl was submitted for
+func (e frontierEntry) isSeed() bool {
+	return e.Depth == 0 && !e.FromSitemap
+}
+
+// crawlSite walks the site breadth-first from the seed URL, then from the
+// sitemap entries not reached by links, following internal links up to the
+// crawl's maximum depth and stopping once its page budget has been fetched for
+// a host. It returns the data
+// of the seed page. When the context is cancelled it stops and returns
+// whatever seed data it has together with the context's error.
+func (cs *CrawlerService) crawlSite(ctx context.Context, session *crawlSession) (*CrawlData, error) {
+	var seedData *CrawlData
+
+	for len(session.frontier) > 0 || session.releaseSitemapURLs() {
+		if err := ctx.Err(); err != nil {
+			return seedData, err
+		}
+
+		entry := session.frontier[0]
+		session.frontier = session.frontier[1:]
+
+		pageURL, err := url.Parse(entry.URL)
+		if err != nil || session.crawled[session.normalizer.Key(entry.URL)] {
+			continue
+		}
+		if session.pagesPerHost[pageURL.Host] >= session.settings.maxPagesPerDomain {
+			continue
+		}
+
+		allowed, err := cs.checkRobots(ctx, entry.URL, session)
+		if ctx.Err() != nil {
+			return seedData, ctx.Err()
+		}
+		if err != nil {
+			continue
+		}
+		if !allowed {
+			session.skip(entry.URL, robotsDisallowedReason)
+			if entry.isSeed() {
+				return nil, categorize(models.ErrorRobotsDisallowed, fmt.Errorf("URL %s", robotsDisallowedReason))
+			}
+			continue
+		}
+		// Only pages that are fetched count against the host's budget
+		session.pagesPerHost[pageURL.Host]++
+
+		page := models.CrawledPage{
+			URL:       entry.URL,
+			Depth:     entry.Depth,
+			CrawledAt: time.Now(),
+		}
+
+		crawlData, err := cs.crawlURL(ctx, entry.URL, session)
+		if ctx.Err() != nil {
+			return seedData, ctx.Err()
+		}
+		if crawlData != nil {
+			session.recordRedirects(crawlData.Redirects)
+			page.Attempts = crawlData.Attempts
+			if entry.isSeed() {
+				session.seedAttempts = crawlData.Attempts
+			}
+			page.RedirectedTo = crawlData.FinalURL
+			if page.RedirectedTo == entry.URL {
+				page.RedirectedTo = ""
+			}
+		}
+		if err != nil {
+			// A failing seed page fails the whole crawl
+			if entry.isSeed() {
+				return nil, err
+			}
+			// Resources that aren't HTML, such as PDFs, are recorded unparsed
+			if category := errorCategory(err); category != models.ErrorContentType {
+				errMsg := err.Error()
+				page.ErrorMessage = &errMsg
+				page.ErrorCategory = category
+			}
+			if crawlData != nil {
+				page.StatusCode = crawlData.StatusCode
+				page.ContentType = crawlData.ContentType
+			}
+			session.pages = append(session.pages, page)
+			continue
+		}
+
+		if entry.isSeed() {
+			// The host the seed redirected to belongs to the site as well
+			session.classifier.addSite(crawlData.FinalURL)
+			seedData = crawlData
+		}
+		session.classifier.classifyLinks(crawlData)
+		session.normalizer.normalizeLinks(crawlData)
+
+		// A redirect may lead to a page this crawl has already processed
+		finalKey := session.normalizer.Key(crawlData.FinalURL)
+		if session.crawled[finalKey] && !entry.isSeed() {
+			page.StatusCode = crawlData.StatusCode
+			session.pages = append(session.pages, page)
+			continue
+		}
+		session.crawled[finalKey] = true
+		session.visited[finalKey] = true
+
+		// External pages only contribute their own metrics
+		if entry.External {
+			setPageMetrics(&page, crawlData)
+			session.pages = append(session.pages, page)
+			continue
+		}
+
+		// Check HTTP links that have not been checked earlier in this crawl
+		var unchecked []string
+		for _, ref := range crawlData.LinkRefs {
+			key := session.normalizer.Key(ref.URL)
+			if session.checkedLinks[key] {
+				continue
+			}
+			session.checkedLinks[key] = true
+			if linkURL, err := url.Parse(ref.URL); err == nil && linkURL.Scheme != "http" && linkURL.Scheme != "https" {
+				continue
+			}
+			if reason := session.scope.check(ref.URL); reason != "" {
+				session.skip(ref.URL, reason)
+				continue
+			}
+			unchecked = append(unchecked, ref.URL)
+		}
+		for _, result := range cs.checkBrokenLinks(ctx, session, unchecked) {
+			if result.disallowed {
+				session.skip(result.url, robotsDisallowedReason)
+			}
+			if result.broken {
+				session.brokenChecks[session.normalizer.Key(result.url)] = result
+			}
+		}
+		if ctx.Err() != nil {
+			return seedData, ctx.Err()
+		}
+
+		// Record where on this page each broken link appears, once per URL
+		onPage := make(map[string]bool)
+		for _, ref := range crawlData.LinkRefs {
+			key := session.normalizer.Key(ref.URL)
+			check, broken := session.brokenChecks[key]
+			if !broken || onPage[key] {
+				continue
+			}
+			onPage[key] = true
+			crawlData.BrokenLinks = append(crawlData.BrokenLinks, models.BrokenLink{
+				URL:        ref.URL,
+				StatusCode: check.statusCode,
+				Attempts:   check.attempts,
+				Text:       ref.Text,
+				SourceURL:  entry.URL,
+				Element:    ref.Element,
+				Attribute:  ref.Attribute,
+			})
+		}
+		session.brokenLinks = append(session.brokenLinks, crawlData.BrokenLinks...)
+
+		for _, link := range crawlData.InternalLinks {
+			key := session.normalizer.Key(link)
+			if _, seen := session.internalLinks[key]; !seen {
+				session.internalLinks[key] = link
+			}
+		}
+		for _, link := range crawlData.ExternalLinks {
+			session.externalLinks[session.normalizer.Key(link)] = true
+		}
+		for _, link := range uniqueSpecialLinks(crawlData.SpecialLinks) {
+			// Same-page links are distinct per page
+			if link.Kind == linkKindFragment {
+				link.Value = finalKey + link.Value
+			}
+			session.specialLinks[link] = true
+		}
+		if crawlData.HasLoginForm {
+			session.hasLoginForm = true
+		}
+
+		setPageMetrics(&page, crawlData)
+		session.pages = append(session.pages, page)
+
+		// Follow internal links until the configured depth is reached
+		if entry.Depth >= session.settings.maxDepth {
+			continue
+		}
+		for _, link := range crawlData.InternalLinks {
+			linkURL, err := url.Parse(link)
+			if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https") {
+				continue
+			}
+			if reason := session.scope.check(link); reason != "" {
+				session.skip(link, reason)
+				continue
+			}
+			session.enqueue(link, entry.Depth+1)
+		}
+		if !session.settings.followExternal {
+			continue
+		}
+		for _, link := range crawlData.ExternalLinks {
+			linkURL, err := url.Parse(link)
+			if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https") {
+				continue
+			}
+			if reason := session.scope.check(link); reason != "" {
+				session.skip(link, reason)
+				continue
+			}
+			session.enqueueExternal(link, entry.Depth+1)
+		}
+	}
+
+	return seedData, nil
+}
+
+// setPageMetrics copies the metrics of a fetched page onto its record
+func setPageMetrics(page *models.CrawledPage, crawlData *CrawlData) {
+	page.StatusCode = crawlData.StatusCode
+	page.ContentType = crawlData.ContentType
+	page.Title = crawlData.Title
+	page.HTMLVersion = crawlData.HTMLVersion
+	page.HeadingCounts = crawlData.HeadingCounts
+	page.InternalLinksCount = len(crawlData.InternalLinks)
+	page.ExternalLinksCount = len(crawlData.ExternalLinks)
+	page.SpecialLinkCounts = countSpecialLinks(uniqueSpecialLinks(crawlData.SpecialLinks))
+	page.BrokenLinksCount = len(crawlData.BrokenLinks)
+	page.HasLoginForm = crawlData.HasLoginForm
+}
+
+// crawlURL fetches a single page and extracts its data. When the server
+// answers with a non-200 status or a resource that isn't HTML, the returned
+// data carries the status code and content type.
+func (cs *CrawlerService) crawlURL(ctx context.Context, targetURL string, session *crawlSession) (*CrawlData, error) {
+	// Fetch the webpage, following redirects
+	resp, chain, attempts, err := cs.fetchPage(ctx, targetURL, session)
+	if err != nil {
+		return &CrawlData{Redirects: chain, Attempts: attempts}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		err := fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		if len(chain.Hops) > 0 {
+			err = fmt.Errorf("HTTP %d: %s after %d redirects: %s", resp.StatusCode, resp.Status, len(chain.Hops), chain.Describe())
+		}
+		return &CrawlData{StatusCode: resp.StatusCode, FinalURL: chain.FinalURL, Redirects: chain, Attempts: attempts}, categorize(models.HTTPErrorCategory(resp.StatusCode), err)
 	}
 
 	// Parse HTML
-	doc, err := html.Parse(resp.Body)
+	doc, contentType, err := parseHTMLResponse(resp, session.settings.maxBodySize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML: %v", err)
+		return &CrawlData{StatusCode: resp.StatusCode, FinalURL: chain.FinalURL, Redirects: chain, Attempts: attempts, ContentType: contentType}, err
 	}
 
 	crawlData := &CrawlData{
+		StatusCode:    resp.StatusCode,
+		FinalURL:      chain.FinalURL,
+		Redirects:     chain,
+		Attempts:      attempts,
+		ContentType:   contentType,
 		HeadingCounts: models.HeadingCounts{},
 		InternalLinks: []string{},
 		ExternalLinks: []string{},
 		BrokenLinks:   []models.BrokenLink{},
 	}
 
-	// Parse URL for domain comparison
-	parsedURL, err := url.Parse(targetURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse target URL: %v", err)
-	}
-
-	// Extract data from HTML
-	cs.extractHTMLData(doc, crawlData, parsedURL.Host, targetURL)
-
-	// Check for broken links
-	cs.checkBrokenLinks(crawlData, client)
+	// Extract data from HTML. Links are relative to the URL the page was
+	// finally served from, unless the page declares a <base>.
+	crawlData.HTMLVersion = detectHTMLVersion(doc)
+	cs.extractHTMLData(doc, crawlData, documentBase(doc, chain.FinalURL))
 
 	return crawlData, nil
 }
 
-func (cs *CrawlerService) extractHTMLData(n *html.Node, data *CrawlData, baseDomain string, baseURL string) {
+func (cs *CrawlerService) extractHTMLData(n *html.Node, data *CrawlData, baseURL string) {
 	if n.Type == html.ElementNode {
 		switch n.Data {
-		case "html":
-			// Check for HTML version in doctype or html tag
-			for _, attr := range n.Attr {
-				if attr.Key == "version" {
-					data.HTMLVersion = "HTML " + attr.Val
-				}
-			}
-			if data.HTMLVersion == "" {
-				data.HTMLVersion = "HTML5" // Default assumption for modern pages
-			}
-
 		case "title":
 			if n.FirstChild != nil {
 				data.Title = strings.TrimSpace(n.FirstChild.Data)
@@ -326,7 +936,33 @@ func (cs *CrawlerService) extractHTMLData(n *html.Node, data *CrawlData, baseDom
 		case "a":
 			for _, attr := range n.Attr {
 				if attr.Key == "href" && attr.Val != "" {
-					cs.categorizeLink(attr.Val, baseDomain, baseURL, data)
+					if link := cs.categorizeLink(attr.Val, baseURL, data); link != "" {
+						data.LinkRefs = append(data.LinkRefs, LinkRef{
+							URL:       link,
+							Text:      anchorText(n),
+							Element:   "a",
+							Attribute: "href",
+						})
+					}
+				}
+			}
+
+		case "img", "script", "link":
+			// Resources are checked for being broken but not counted as links
+			attrName := "src"
+			if n.Data == "link" {
+				attrName = "href"
+			}
+			for _, attr := range n.Attr {
+				if attr.Key == attrName && attr.Val != "" {
+					if link := resolveLink(attr.Val, baseURL); link != "" {
+						data.LinkRefs = append(data.LinkRefs, LinkRef{
+							URL:       link,
+							Text:      attributeValue(n, "alt"),
+							Element:   n.Data,
+							Attribute: attrName,
+						})
+					}
 				}
 			}
 
@@ -348,42 +984,90 @@ func (cs *CrawlerService) extractHTMLData(n *html.Node, data *CrawlData, baseDom
 
 	// Recursively process child nodes
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		cs.extractHTMLData(c, data, baseDomain, baseURL)
+		cs.extractHTMLData(c, data, baseURL)
 	}
 }
 
-func (cs *CrawlerService) categorizeLink(href, baseDomain, baseURL string, data *CrawlData) {
+// categorizeLink records the link as a page link or as a non-navigational
+// link and returns the absolute URL of page links, or "" otherwise. Page links
+// are classified as internal or external once the crawl has seen the page.
+func (cs *CrawlerService) categorizeLink(href, baseURL string, data *CrawlData) string {
 	// Parse the link
+	href = strings.TrimSpace(href)
 	linkURL, err := url.Parse(href)
 	if err != nil {
-		return
+		return ""
+	}
+
+	// mailto:, tel:, javascript: and same-page links are counted separately
+	if kind := specialLinkKind(href, linkURL); kind != "" {
+		data.SpecialLinks = append(data.SpecialLinks, specialLink{Kind: kind, Value: href})
+		return ""
+	}
+
+	// Resolve relative and protocol-relative URLs
+	resolved := resolveLink(href, baseURL)
+	if resolved != "" {
+		data.Links = append(data.Links, resolved)
+	}
+	return resolved
+}
+
+// resolveLink resolves a possibly relative reference against the page URL
+func resolveLink(href, baseURL string) string {
+	linkURL, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
 	}
+	baseURLParsed, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return baseURLParsed.ResolveReference(linkURL).String()
+}
 
-	// Make relative URLs absolute
-	if linkURL.IsAbs() {
-		if linkURL.Host == baseDomain {
-			data.InternalLinks = append(data.InternalLinks, href)
-		} else {
-			data.ExternalLinks = append(data.ExternalLinks, href)
+// anchorText returns the visible text of a link, falling back to its title
+// and aria-label attributes for links without text such as icon links
+func anchorText(n *html.Node) string {
+	var text strings.Builder
+	var collect func(*html.Node)
+	collect = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			text.WriteString(node.Data)
+			text.WriteString(" ")
 		}
-	} else {
-		// Resolve relative URL
-		baseURLParsed, err := url.Parse(baseURL)
-		if err != nil {
-			return
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
 		}
-		resolvedURL := baseURLParsed.ResolveReference(linkURL)
-		data.InternalLinks = append(data.InternalLinks, resolvedURL.String())
 	}
+	collect(n)
+
+	if collapsed := strings.Join(strings.Fields(text.String()), " "); collapsed != "" {
+		return collapsed
+	}
+	if title := attributeValue(n, "title"); title != "" {
+		return title
+	}
+	return attributeValue(n, "aria-label")
+}
+
+// attributeValue returns the trimmed value of an attribute, or ""
+func attributeValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return ""
 }
 
 func (cs *CrawlerService) isLoginForm(n *html.Node) bool {
 	// Look for common login form indicators
 	hasPasswordField := false
 	hasUsernameField := false
-	
+
 	cs.checkLoginFormFields(n, &hasPasswordField, &hasUsernameField)
-	
+
 	return hasPasswordField && hasUsernameField
 }
 
@@ -392,7 +1076,7 @@ func (cs *CrawlerService) checkLoginFormFields(n *html.Node, hasPassword *bool,
 		inputType := ""
 		inputName := ""
 		inputId := ""
-		
+
 		for _, attr := range n.Attr {
 			switch attr.Key {
 			case "type":
@@ -403,70 +1087,28 @@ func (cs *CrawlerService) checkLoginFormFields(n *html.Node, hasPassword *bool,
 				inputId = strings.ToLower(attr.Val)
 			}
 		}
-		
+
 		if inputType == "password" {
 			*hasPassword = true
 		}
-		
+
 		if inputType == "text" || inputType == "email" {
 			if strings.Contains(inputName, "user") || strings.Contains(inputName, "email") ||
-			   strings.Contains(inputName, "login") || strings.Contains(inputId, "user") ||
-			   strings.Contains(inputId, "email") || strings.Contains(inputId, "login") {
+				strings.Contains(inputName, "login") || strings.Contains(inputId, "user") ||
+				strings.Contains(inputId, "email") || strings.Contains(inputId, "login") {
 				*hasUsername = true
 			}
 		}
 	}
-	
+
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
 		cs.checkLoginFormFields(c, hasPassword, hasUsername)
 	}
 }
 
-func (cs *CrawlerService) checkBrokenLinks(data *CrawlData, client *http.Client) {
-	// Check a sample of links (to avoid too many requests)
-	allLinks := append(data.InternalLinks, data.ExternalLinks...)
-	
-	// Limit to first 10 links to avoid overwhelming the server
-	maxLinksToCheck := 10
-	if len(allLinks) > maxLinksToCheck {
-		allLinks = allLinks[:maxLinksToCheck]
-	}
-	
-	for _, link := range allLinks {
-		if cs.isLinkBroken(link, client) {
-			// Get the status code
-			resp, err := client.Head(link)
-			statusCode := 0
-			if err == nil {
-				statusCode = resp.StatusCode
-				resp.Body.Close()
-			} else {
-				statusCode = 0 // Connection error
-			}
-			
-			data.BrokenLinks = append(data.BrokenLinks, models.BrokenLink{
-				URL:        link,
-				StatusCode: statusCode,
-				Text:       "Link text", // In a real implementation, we'd extract the actual link text
-			})
-		}
-	}
-}
-
-func (cs *CrawlerService) isLinkBroken(link string, client *http.Client) bool {
-	// Quick HEAD request to check if link is accessible
-	resp, err := client.Head(link)
-	if err != nil {
-		return true
-	}
-	defer resp.Body.Close()
-	
-	// Consider 4xx and 5xx as broken
-	return resp.StatusCode >= 400
-}
-
 func (cs *CrawlerService) IsCrawlActive(crawlResultID string) bool {
 	cs.mutex.RLock()
 	defer cs.mutex.RUnlock()
-	return cs.activeCrawls[crawlResultID]
+	_, active := cs.activeCrawls[crawlResultID]
+	return active
 }
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"webcrawler/models"
+)
+
+// categorizedError is an error whose category was known where it happened
+type categorizedError struct {
+	category string
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// categorize attaches a category to an error
+func categorize(category string, err error) error {
+	return &categorizedError{category: category, err: err}
+}
+
+// errorCategory returns the category of a crawl or page error
+func errorCategory(err error) string {
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category
+	}
+	return requestErrorCategory(err)
+}
+
+// requestErrorCategory classifies the error of a failed HTTP request by the
+// network or TLS failure behind it
+func requestErrorCategory(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return models.ErrorCancelled
+	case errors.As(err, &dnsErr):
+		return models.ErrorDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &unknownAuthority),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidCert):
+		return models.ErrorTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return models.ErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return models.ErrorConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return models.ErrorConnectionReset
+	case strings.Contains(err.Error(), "tls: "):
+		// TLS alerts from the server have no exported error type
+		return models.ErrorTLS
+	}
+	return models.ErrorOther
+}
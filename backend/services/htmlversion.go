@@ -0,0 +1,91 @@
+package services
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// quirksMode is reported for documents without a DOCTYPE
+const quirksMode = "Quirks mode (no DOCTYPE)"
+
+// doctypeVersions maps public identifier fragments to HTML versions. More
+// specific fragments come first since e.g. "HTML 4.0" prefixes "HTML 4.01".
+var doctypeVersions = []struct {
+	fragment string
+	version  string
+}{
+	{"-//w3c//dtd html 4.01 transitional//", "HTML 4.01 Transitional"},
+	{"-//w3c//dtd html 4.01 frameset//", "HTML 4.01 Frameset"},
+	{"-//w3c//dtd html 4.01//", "HTML 4.01 Strict"},
+	{"-//w3c//dtd html 4.0 transitional//", "HTML 4.0 Transitional"},
+	{"-//w3c//dtd html 4.0 frameset//", "HTML 4.0 Frameset"},
+	{"-//w3c//dtd html 4.0//", "HTML 4.0 Strict"},
+	{"-//w3c//dtd xhtml 1.0 transitional//", "XHTML 1.0 Transitional"},
+	{"-//w3c//dtd xhtml 1.0 frameset//", "XHTML 1.0 Frameset"},
+	{"-//w3c//dtd xhtml 1.0 strict//", "XHTML 1.0 Strict"},
+	{"-//w3c//dtd xhtml 1.1//", "XHTML 1.1"},
+	{"-//w3c//dtd xhtml basic 1.1//", "XHTML Basic 1.1"},
+	{"-//w3c//dtd xhtml basic 1.0//", "XHTML Basic 1.0"},
+	{"-//w3c//dtd xhtml+rdfa 1.", "XHTML+RDFa"},
+	{"-//wapforum//dtd xhtml mobile 1.", "XHTML Mobile"},
+	{"-//w3c//dtd html 3.2", "HTML 3.2"},
+	{"-//ietf//dtd html 2.0", "HTML 2.0"},
+	{"-//ietf//dtd html//", "HTML 2.0"},
+}
+
+// detectHTMLVersion derives the HTML version from the document's DOCTYPE
+// public and system identifiers
+func detectHTMLVersion(doc *html.Node) string {
+	var doctype *html.Node
+	for c := doc.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.DoctypeNode {
+			doctype = c
+			break
+		}
+	}
+	if doctype == nil || !strings.EqualFold(doctype.Data, "html") {
+		return quirksMode
+	}
+
+	var publicID, systemID string
+	for _, attr := range doctype.Attr {
+		switch attr.Key {
+		case "public":
+			publicID = strings.ToLower(strings.TrimSpace(attr.Val))
+		case "system":
+			systemID = strings.ToLower(strings.TrimSpace(attr.Val))
+		}
+	}
+
+	// <!DOCTYPE html> and the legacy-compat form used by XML serializers
+	if publicID == "" && (systemID == "" || systemID == "about:legacy-compat") {
+		return "HTML5"
+	}
+
+	for _, known := range doctypeVersions {
+		if strings.HasPrefix(publicID, known.fragment) {
+			return known.version
+		}
+	}
+
+	// Some documents only carry the DTD's system identifier
+	switch {
+	case strings.Contains(systemID, "xhtml11.dtd"):
+		return "XHTML 1.1"
+	case strings.Contains(systemID, "xhtml1-strict.dtd"):
+		return "XHTML 1.0 Strict"
+	case strings.Contains(systemID, "xhtml1-transitional.dtd"):
+		return "XHTML 1.0 Transitional"
+	case strings.Contains(systemID, "xhtml1-frameset.dtd"):
+		return "XHTML 1.0 Frameset"
+	case strings.HasSuffix(systemID, "/html4/loose.dtd"):
+		return "HTML 4.01 Transitional"
+	case strings.HasSuffix(systemID, "/html4/frameset.dtd"):
+		return "HTML 4.01 Frameset"
+	case strings.HasSuffix(systemID, "/html4/strict.dtd"):
+		return "HTML 4.01 Strict"
+	}
+
+	return "Unknown DOCTYPE"
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"webcrawler/models"
+)
+
+const (
+	// defaultLinkCheckConcurrency is the number of links checked in parallel
+	defaultLinkCheckConcurrency = 10
+	// defaultLinkCheckPerHost is the number of parallel checks against one host
+	defaultLinkCheckPerHost = 2
+)
+
+// linkCheckResult is the outcome of checking a single link
+type linkCheckResult struct {
+	url        string
+	statusCode int
+	broken     bool
+	disallowed bool
+	// attempts is the number of times the deciding request was sent
+	attempts int
+}
+
+// linkChecker checks links with a bounded worker pool and caps the number
+// of parallel requests per host for the whole crawl
+type linkChecker struct {
+	maxLinks    int
+	concurrency int
+	perHost     int
+	checked     int
+	hostSlots   map[string]chan struct{}
+	mutex       sync.Mutex
+}
+
+// newLinkChecker applies the crawl's link-check options over the defaults
+func newLinkChecker(options *models.LinkCheckOptions) *linkChecker {
+	lc := &linkChecker{
+		concurrency: defaultLinkCheckConcurrency,
+		perHost:     defaultLinkCheckPerHost,
+		hostSlots:   make(map[string]chan struct{}),
+	}
+	if options != nil {
+		lc.maxLinks = options.MaxLinks
+		if options.Concurrency > 0 {
+			lc.concurrency = options.Concurrency
+		}
+		if options.PerHostConcurrency > 0 {
+			lc.perHost = options.PerHostConcurrency
+		}
+	}
+	return lc
+}
+
+// budget trims links to what is left of the crawl's link-check budget
+func (lc *linkChecker) budget(links []string) []string {
+	if lc.maxLinks > 0 {
+		remaining := lc.maxLinks - lc.checked
+		if remaining <= 0 {
+			return nil
+		}
+		if len(links) > remaining {
+			links = links[:remaining]
+		}
+	}
+	lc.checked += len(links)
+	return links
+}
+
+// acquireHost waits for a free slot on the link's host
+func (lc *linkChecker) acquireHost(ctx context.Context, host string) (func(), error) {
+	lc.mutex.Lock()
+	slots, ok := lc.hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, lc.perHost)
+		lc.hostSlots[host] = slots
+	}
+	lc.mutex.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// checkBrokenLinks checks every link once and returns the results in the
+// order of the input. Links disallowed by robots.txt are not requested.
+func (cs *CrawlerService) checkBrokenLinks(ctx context.Context, session *crawlSession, links []string) []linkCheckResult {
+	links = session.linkChecker.budget(links)
+	results := make([]linkCheckResult, len(links))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := session.linkChecker.concurrency
+	if workers > len(links) {
+		workers = len(links)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = cs.checkLink(ctx, session, links[i])
+			}
+		}()
+	}
+
+	for i := range links {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// checkLink requests a link with HEAD, falling back to GET when the server
+// does not support HEAD, and retries transient failures. 4xx, 5xx and
+// connection errors are broken.
+func (cs *CrawlerService) checkLink(ctx context.Context, session *crawlSession, link string) linkCheckResult {
+	result := linkCheckResult{url: link}
+
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		result.broken = true
+		return result
+	}
+
+	release, err := session.linkChecker.acquireHost(ctx, linkURL.Host)
+	if err != nil {
+		return result
+	}
+	defer release()
+
+	allowed, err := cs.checkRobots(ctx, link, session)
+	if err != nil {
+		return result
+	}
+	if !allowed {
+		result.disallowed = true
+		return result
+	}
+
+	resp, attempts, err := linkRequest(ctx, http.MethodHead, link, session)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, attempts, err = linkRequest(ctx, http.MethodGet, link, session)
+	}
+	result.attempts = attempts
+	if err != nil {
+		// Connection error
+		result.broken = ctx.Err() == nil
+		return result
+	}
+	resp.Body.Close()
+
+	result.statusCode = resp.StatusCode
+	result.broken = resp.StatusCode >= 400
+	return result
+}
+
+// linkRequest sends a request bound to the crawl's context, retrying it
+// according to the crawl's retry policy
+func linkRequest(ctx context.Context, method, link string, session *crawlSession) (*http.Response, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return session.settings.retry.do(session.client, req)
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+
+	"webcrawler/models"
+)
+
+// Kinds of links that don't lead to another page
+const (
+	linkKindMailto     = "mailto"
+	linkKindTel        = "tel"
+	linkKindJavascript = "javascript"
+	linkKindFragment   = "fragment"
+	linkKindOther      = "other"
+)
+
+// specialLink is a non-navigational link found on a page
+type specialLink struct {
+	Kind  string
+	Value string
+}
+
+// specialLinkKind returns the kind of a non-navigational href, or "" for
+// links to HTTP(S) pages
+func specialLinkKind(href string, linkURL *url.URL) string {
+	if strings.HasPrefix(href, "#") {
+		return linkKindFragment
+	}
+	switch strings.ToLower(linkURL.Scheme) {
+	case "", "http", "https":
+		return ""
+	case "mailto":
+		return linkKindMailto
+	case "tel":
+		return linkKindTel
+	case "javascript":
+		return linkKindJavascript
+	default:
+		return linkKindOther
+	}
+}
+
+// uniqueSpecialLinks drops links that appear more than once
+func uniqueSpecialLinks(links []specialLink) []specialLink {
+	seen := make(map[specialLink]bool, len(links))
+	unique := make([]specialLink, 0, len(links))
+	for _, link := range links {
+		if !seen[link] {
+			seen[link] = true
+			unique = append(unique, link)
+		}
+	}
+	return unique
+}
+
+// countSpecialLinks counts non-navigational links by kind
+func countSpecialLinks(links []specialLink) models.SpecialLinkCounts {
+	var counts models.SpecialLinkCounts
+	for _, link := range links {
+		switch link.Kind {
+		case linkKindMailto:
+			counts.Mailto++
+		case linkKindTel:
+			counts.Tel++
+		case linkKindJavascript:
+			counts.Javascript++
+		case linkKindFragment:
+			counts.Fragment++
+		default:
+			counts.Other++
+		}
+	}
+	return counts
+}
+
+// documentBase returns the URL relative links of the page resolve against:
+// the first <base href> resolved against the page URL, or the page URL itself
+func documentBase(doc *html.Node, pageURL string) string {
+	var base string
+	var find func(*html.Node) bool
+	find = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "base" {
+			for _, attr := range n.Attr {
+				if attr.Key == "href" && strings.TrimSpace(attr.Val) != "" {
+					base = resolveLink(attr.Val, pageURL)
+					return true
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if find(c) {
+				return true
+			}
+		}
+		return false
+	}
+	find(doc)
+
+	if base == "" {
+		return pageURL
+	}
+	return base
+}
+
+// linkClassifier decides whether a link is internal to the crawled site
+type linkClassifier struct {
+	mode      string
+	siteHosts map[string]bool
+	domains   []string
+}
+
+// newLinkClassifier builds the classifier of a crawl starting at seedURL
+func newLinkClassifier(options *models.ClassificationOptions, seedURL string) *linkClassifier {
+	c := &linkClassifier{
+		mode:      models.ClassifyByHost,
+		siteHosts: make(map[string]bool),
+	}
+	if options != nil {
+		if options.Mode != "" {
+			c.mode = options.Mode
+		}
+		for _, domain := range options.Domains {
+			c.domains = append(c.domains, normalizeHostname(domain))
+		}
+	}
+	c.addSite(seedURL)
+	return c
+}
+
+// addSite marks the host of a URL as belonging to the crawled site, e.g. the
+// host the seed page redirected to
+func (c *linkClassifier) addSite(siteURL string) {
+	u, err := url.Parse(siteURL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	host := normalizeHostname(u.Hostname())
+	c.siteHosts[host] = true
+	if c.mode == models.ClassifyByDomain {
+		c.siteHosts[registrableDomain(host)] = true
+	}
+}
+
+// isInternal reports whether an absolute link belongs to the crawled site
+func (c *linkClassifier) isInternal(link *url.URL) bool {
+	host := normalizeHostname(link.Hostname())
+	if host == "" {
+		return false
+	}
+	if c.siteHosts[host] {
+		return true
+	}
+
+	switch c.mode {
+	case models.ClassifyByDomain:
+		return c.siteHosts[registrableDomain(host)]
+	case models.ClassifyByDomainList:
+		for _, domain := range c.domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classifyLinks sorts the page's links into internal and external links
+func (c *linkClassifier) classifyLinks(data *CrawlData) {
+	for _, link := range data.Links {
+		linkURL, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		if c.isInternal(linkURL) {
+			data.InternalLinks = append(data.InternalLinks, link)
+		} else {
+			data.ExternalLinks = append(data.ExternalLinks, link)
+		}
+	}
+}
+
+// normalizeHostname lowercases a host name and drops a trailing dot
+func normalizeHostname(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// registrableDomain returns the domain a host was registered under, such as
+// example.co.uk for www.example.co.uk. IP addresses and single-label hosts
+// are returned unchanged.
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
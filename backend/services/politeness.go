@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRetryAfter caps how long a Retry-After header can pause a host
+	maxRetryAfter = 5 * time.Minute
+	// hostStateExpiry is how long the delays of an idle host are remembered
+	hostStateExpiry = 10 * time.Minute
+)
+
+// hostState tracks the requests the crawler sends to one host
+type hostState struct {
+	// nextSlot is the earliest time the next request may start
+	nextSlot time.Time
+	// pausedUntil is set from Retry-After on 429 and 503 responses
+	pausedUntil time.Time
+	crawlDelay  time.Duration
+	// throttle is the adaptive delay set from the host's latency and errors
+	throttle time.Duration
+	active   int
+	// released is closed and replaced whenever a connection is released
+	released chan struct{}
+}
+
+// hostScheduler spaces out and limits the requests sent to each host. It is
+// shared by every crawl in the process, so concurrent crawls of the same site
+// don't add up to a burst.
+type hostScheduler struct {
+	mutex          sync.Mutex
+	interval       time.Duration
+	maxConnections int
+	autoThrottle   bool
+	maxThrottle    time.Duration
+	hosts          map[string]*hostState
+	lastSweep      time.Time
+}
+
+func newHostScheduler() *hostScheduler {
+	return &hostScheduler{hosts: make(map[string]*hostState)}
+}
+
+// configure sets the per-host request rate (0 for unlimited) and the number
+// of requests that may be in flight to one host at a time
+func (hs *hostScheduler) configure(requestsPerSecond float64, maxConnections int) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	hs.interval = 0
+	if requestsPerSecond > 0 {
+		hs.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	hs.maxConnections = maxConnections
+	// Wake waiters in case the connection limit was raised
+	for _, state := range hs.hosts {
+		state.signal()
+	}
+}
+
+// host returns the state of a host, creating it when needed. The caller must
+// hold the mutex.
+func (hs *hostScheduler) host(host string) *hostState {
+	host = strings.ToLower(host)
+	state, ok := hs.hosts[host]
+	if !ok {
+		hs.sweep(time.Now())
+		state = &hostState{released: make(chan struct{})}
+		hs.hosts[host] = state
+	}
+	return state
+}
+
+// sweep forgets hosts that have been idle for a while, at most once a
+// minute. The caller must hold the mutex.
+func (hs *hostScheduler) sweep(now time.Time) {
+	if now.Sub(hs.lastSweep) < time.Minute {
+		return
+	}
+	hs.lastSweep = now
+	for host, state := range hs.hosts {
+		if state.active == 0 && now.After(state.pausedUntil) && now.Sub(state.nextSlot) > hostStateExpiry {
+			delete(hs.hosts, host)
+		}
+	}
+}
+
+// signal wakes everyone waiting for a connection to the host
+func (s *hostState) signal() {
+	close(s.released)
+	s.released = make(chan struct{})
+}
+
+// setCrawlDelay applies a robots.txt Crawl-delay on top of the request rate
+func (hs *hostScheduler) setCrawlDelay(host string, delay time.Duration) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	hs.host(host).crawlDelay = delay
+}
+
+// acquire waits for a free connection and the host's next request slot. The
+// returned function releases the connection and must be called exactly once.
+// Waiters claim nothing until they get through, so a cancelled waiter leaves
+// the host's schedule as it was.
+func (hs *hostScheduler) acquire(ctx context.Context, host string) (func(), error) {
+	hs.mutex.Lock()
+	for {
+		state := hs.host(host)
+		released := state.released
+		wait := time.Duration(0)
+		if hs.maxConnections <= 0 || state.active < hs.maxConnections {
+			now := time.Now()
+			slot := now
+			if state.nextSlot.After(slot) {
+				slot = state.nextSlot
+			}
+			if state.pausedUntil.After(slot) {
+				slot = state.pausedUntil
+			}
+			if wait = slot.Sub(now); wait <= 0 {
+				state.active++
+				state.nextSlot = now.Add(hs.spacing(state))
+				hs.mutex.Unlock()
+
+				var once sync.Once
+				return func() {
+					once.Do(func() { hs.release(host) })
+				}, nil
+			}
+		}
+		hs.mutex.Unlock()
+
+		// Wait for the slot or, at the connection limit, for a release, then
+		// check again as another waiter may have been faster
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-timeout:
+		case <-released:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hs.mutex.Lock()
+	}
+}
+
+// spacing returns the time between the starts of two requests to a host.
+// The caller must hold the mutex.
+func (hs *hostScheduler) spacing(state *hostState) time.Duration {
+	spacing := hs.interval
+	if state.crawlDelay > spacing {
+		spacing = state.crawlDelay
+	}
+	if hs.autoThrottle && state.throttle > spacing {
+		spacing = state.throttle
+	}
+	return spacing
+}
+
+// release frees a connection and forgets hosts that are idle and have no
+// pending or raised delay
+func (hs *hostScheduler) release(host string) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	host = strings.ToLower(host)
+	state := hs.hosts[host]
+	if state == nil {
+		return
+	}
+	state.active--
+	state.signal()
+
+	now := time.Now()
+	if state.active == 0 && state.crawlDelay == 0 && state.throttle <= hs.interval &&
+		now.After(state.nextSlot) && now.After(state.pausedUntil) {
+		delete(hs.hosts, host)
+	}
+}
+
+// observe pauses the host when a 429 or 503 response asks us to retry later
+func (hs *hostScheduler) observe(host string, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	state := hs.host(host)
+	if until := time.Now().Add(delay); until.After(state.pausedUntil) {
+		state.pausedUntil = until
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// releasingBody releases the host connection when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"webcrawler/models"
+
+	"gorm.io/gorm"
+)
+
+// queuePollInterval is how often idle workers look for jobs enqueued by
+// other processes or missed wake-ups
+const queuePollInterval = 2 * time.Second
+
+// StartWorkers recovers crawls orphaned by a previous process and starts the
+// worker pool that drains the crawl queue. The pool size is the maximum
+// number of concurrent crawls.
+func (cs *CrawlerService) StartWorkers() {
+	go cs.watchOrphanedCrawls()
+
+	cs.runtimeMutex.Lock()
+	cs.workersStarted = true
+	cs.runtimeMutex.Unlock()
+	cs.resizeWorkers()
+}
+
+// enqueueCrawl adds a crawl to the persistent queue and marks it queued
+func (cs *CrawlerService) enqueueCrawl(crawlResultID string, priority int) error {
+	if cs.isShuttingDown() {
+		return errShuttingDown
+	}
+
+	cs.queueMutex.Lock()
+	defer cs.queueMutex.Unlock()
+
+	var crawlResult models.CrawlResult
+	if err := cs.db.First(&crawlResult, "id = ?", crawlResultID).Error; err != nil {
+		return fmt.Errorf("crawl result not found: %s", crawlResultID)
+	}
+
+	var existing int64
+	cs.db.Model(&models.CrawlJob{}).Where("crawl_result_id = ?", crawlResultID).Count(&existing)
+	if existing > 0 || cs.IsCrawlActive(crawlResultID) {
+		return fmt.Errorf("crawl already queued or in progress for ID: %s", crawlResultID)
+	}
+
+	job := &models.CrawlJob{
+		CrawlResultID: crawlResultID,
+		Priority:      priority,
+		Status:        models.JobPending,
+	}
+	if err := cs.db.Create(job).Error; err != nil {
+		return err
+	}
+
+	if err := cs.db.Model(&crawlResult).Updates(map[string]interface{}{
+		"status":         models.StatusQueued,
+		"error_message":  nil,
+		"error_category": "",
+	}).Error; err != nil {
+		return err
+	}
+
+	cs.wakeWorker()
+	return nil
+}
+
+// dequeueCrawl removes a crawl's job that no worker has claimed yet and
+// reports whether there was one
+func (cs *CrawlerService) dequeueCrawl(crawlResultID string) bool {
+	result := cs.db.Where("crawl_result_id = ? AND status = ?", crawlResultID, models.JobPending).
+		Delete(&models.CrawlJob{})
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// wakeWorker nudges an idle worker without blocking
+func (cs *CrawlerService) wakeWorker() {
+	select {
+	case cs.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (cs *CrawlerService) worker() {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Leave the pool when the concurrency limit was lowered
+		if cs.retireWorker() {
+			return
+		}
+
+		// Register with the running group before claiming so that Shutdown
+		// either waits for this job or sees that no job will be claimed
+		cs.mutex.Lock()
+		if cs.shuttingDown {
+			cs.mutex.Unlock()
+			return
+		}
+		cs.running.Add(1)
+		cs.mutex.Unlock()
+
+		job, ctx, err := cs.claimNextJob()
+		if err != nil {
+			log.Printf("Failed to claim crawl job: %v", err)
+		}
+		if job != nil {
+			// Let another idle worker check for more work
+			cs.wakeWorker()
+			cs.runJob(ctx, job)
+			cs.running.Done()
+			continue
+		}
+		cs.running.Done()
+
+		select {
+		case <-cs.wake:
+		case <-ticker.C:
+		case <-cs.quit:
+			return
+		}
+	}
+}
+
+// claimNextJob atomically moves the next pending job to running and returns
+// it with the context its crawl runs in. It returns nil when the queue is
+// empty.
+func (cs *CrawlerService) claimNextJob() (*models.CrawlJob, context.Context, error) {
+	for {
+		var jobs []models.CrawlJob
+		if err := cs.db.Where("status = ?", models.JobPending).
+			Order("priority desc, id asc").Limit(1).Find(&jobs).Error; err != nil {
+			return nil, nil, err
+		}
+		if len(jobs) == 0 {
+			return nil, nil, nil
+		}
+
+		// The crawl can be stopped as soon as its job stops being pending:
+		// StopCrawl either dequeues the pending job or finds this cancel
+		job := jobs[0]
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cs.mutex.Lock()
+		cs.activeCrawls[job.CrawlResultID] = cancel
+		cs.mutex.Unlock()
+
+		now := time.Now()
+		result := cs.db.Model(&models.CrawlJob{}).
+			Where("id = ? AND status = ?", job.ID, models.JobPending).
+			Updates(map[string]interface{}{
+				"status":       models.JobRunning,
+				"started_at":   now,
+				"heartbeat_at": now,
+				"attempts":     gorm.Expr("attempts + 1"),
+			})
+		// Another worker claimed it first, or it was dequeued; try the next one
+		if result.Error != nil || result.RowsAffected == 0 {
+			cs.mutex.Lock()
+			delete(cs.activeCrawls, job.CrawlResultID)
+			cs.mutex.Unlock()
+			cancel(nil)
+			if result.Error != nil {
+				return nil, nil, result.Error
+			}
+			continue
+		}
+
+		job.Status = models.JobRunning
+		job.StartedAt = &now
+		job.HeartbeatAt = &now
+		job.Attempts++
+		return &job, ctx, nil
+	}
+}
+
+// runJob performs the job's crawl while renewing its lease, then removes the
+// job from the queue. Jobs interrupted by shutdown are requeued instead.
+func (cs *CrawlerService) runJob(ctx context.Context, job *models.CrawlJob) {
+	stopHeartbeat := make(chan struct{})
+	go cs.heartbeat(job.ID, stopHeartbeat)
+	checkpointed := cs.performCrawl(ctx, job.CrawlResultID)
+	close(stopHeartbeat)
+
+	if checkpointed {
+		cs.requeueJob(job)
+		return
+	}
+
+	if err := cs.db.Delete(&models.CrawlJob{}, job.ID).Error; err != nil {
+		log.Printf("Failed to remove crawl job %d: %v", job.ID, err)
+	}
+}
+
+// queuePositions returns the 1-based queue position of every pending crawl
+func (cs *CrawlerService) queuePositions() map[string]int {
+	var jobs []models.CrawlJob
+	cs.db.Select("crawl_result_id").Where("status = ?", models.JobPending).
+		Order("priority desc, id asc").Find(&jobs)
+
+	positions := make(map[string]int, len(jobs))
+	for i, job := range jobs {
+		positions[job.CrawlResultID] = i + 1
+	}
+	return positions
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"webcrawler/models"
+)
+
+// OrphanPolicy decides what happens to crawls whose worker disappeared
+type OrphanPolicy string
+
+const (
+	// OrphanRequeue puts orphaned crawls back on the queue
+	OrphanRequeue OrphanPolicy = "requeue"
+	// OrphanError marks orphaned crawls as failed
+	OrphanError OrphanPolicy = "error"
+)
+
+// maxJobAttempts stops a crawl that keeps taking its worker down from being
+// requeued forever
+const maxJobAttempts = 3
+
+// orphanedCrawlMessage is stored on crawls that were marked failed by recovery
+const orphanedCrawlMessage = "crawl interrupted: its worker stopped before finishing"
+
+// heartbeat renews the lease of a running job until stop is closed
+func (cs *CrawlerService) heartbeat(jobID uint, stop <-chan struct{}) {
+	ticker := time.NewTicker(cs.leaseTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := cs.db.Model(&models.CrawlJob{}).Where("id = ?", jobID).
+				Update("heartbeat_at", time.Now()).Error; err != nil {
+				log.Printf("Failed to renew lease of crawl job %d: %v", jobID, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// watchOrphanedCrawls reconciles the database against live workers right
+// away and then every half lease
+func (cs *CrawlerService) watchOrphanedCrawls() {
+	cs.recoverOrphanedCrawls()
+
+	ticker := time.NewTicker(cs.leaseTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cs.recoverOrphanedCrawls()
+		case <-cs.quit:
+			return
+		}
+	}
+}
+
+// recoverOrphanedCrawls finds running jobs whose lease expired and crawls
+// left in the running state without any job, and requeues or fails them
+// according to the orphan policy
+func (cs *CrawlerService) recoverOrphanedCrawls() {
+	cutoff := time.Now().Add(-cs.leaseTimeout)
+
+	var jobs []models.CrawlJob
+	if err := cs.db.Where("status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", models.JobRunning, cutoff).
+		Find(&jobs).Error; err != nil {
+		log.Printf("Failed to look up orphaned crawl jobs: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if cs.IsCrawlActive(job.CrawlResultID) {
+			continue
+		}
+
+		if cs.orphanPolicy == OrphanRequeue && job.Attempts < maxJobAttempts {
+			// Only requeue if nobody renewed the lease in the meantime
+			result := cs.db.Model(&models.CrawlJob{}).
+				Where("id = ? AND status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", job.ID, models.JobRunning, cutoff).
+				Updates(map[string]interface{}{"status": models.JobPending, "heartbeat_at": nil})
+			if result.Error != nil || result.RowsAffected == 0 {
+				continue
+			}
+			cs.db.Model(&models.CrawlResult{}).Where("id = ?", job.CrawlResultID).
+				Update("status", models.StatusQueued)
+			log.Printf("Requeued orphaned crawl %s (attempt %d)", job.CrawlResultID, job.Attempts)
+			cs.wakeWorker()
+			continue
+		}
+
+		result := cs.db.Where("id = ? AND status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)", job.ID, models.JobRunning, cutoff).
+			Delete(&models.CrawlJob{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		cs.failOrphanedCrawl(job.CrawlResultID)
+	}
+
+	// Crawls marked running that have no job at all, e.g. left behind by a
+	// crash before the queue existed
+	var crawls []models.CrawlResult
+	if err := cs.db.Where("status = ?", models.StatusRunning).
+		Where("id NOT IN (?)", cs.db.Model(&models.CrawlJob{}).Select("crawl_result_id")).
+		Find(&crawls).Error; err != nil {
+		log.Printf("Failed to look up orphaned crawls: %v", err)
+		return
+	}
+
+	for _, crawl := range crawls {
+		if cs.IsCrawlActive(crawl.ID) {
+			continue
+		}
+		if cs.orphanPolicy == OrphanRequeue {
+			if err := cs.enqueueCrawl(crawl.ID, 0); err != nil {
+				log.Printf("Failed to requeue orphaned crawl %s: %v", crawl.ID, err)
+			} else {
+				log.Printf("Requeued orphaned crawl %s", crawl.ID)
+			}
+			continue
+		}
+		cs.failOrphanedCrawl(crawl.ID)
+	}
+}
+
+// failOrphanedCrawl marks an orphaned crawl as failed
+func (cs *CrawlerService) failOrphanedCrawl(crawlResultID string) {
+	errMsg := orphanedCrawlMessage
+	cs.db.Model(&models.CrawlResult{}).Where("id = ?", crawlResultID).
+		Updates(map[string]interface{}{
+			"status":         models.StatusError,
+			"error_message":  &errMsg,
+			"error_category": models.ErrorInterrupted,
+		})
+	log.Printf("Marked orphaned crawl %s as failed", crawlResultID)
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"webcrawler/models"
+)
+
+const (
+	// maxRedirects is the number of redirects in a chain at which a fetch
+	// gives up
+	maxRedirects = 10
+	// longRedirectChain is the number of hops from which a chain is flagged
+	longRedirectChain = 3
+)
+
+// Redirect issues flagged on a chain
+const (
+	RedirectIssueLoop           = "redirect_loop"
+	RedirectIssueLongChain      = "long_chain"
+	RedirectIssueTooMany        = "too_many_redirects"
+	RedirectIssueHTTPSDowngrade = "https_downgrade"
+	RedirectIssueTemporary      = "temporary_redirect_for_permanent_move"
+)
+
+// isRedirect reports whether the status code is a redirect carrying a Location
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// fetchPage requests a page and follows its redirects one hop at a time,
+// recording each hop and retrying transient failures. It also returns the
+// number of attempts of the last request. The returned chain is never nil,
+// even on error.
+func (cs *CrawlerService) fetchPage(ctx context.Context, targetURL string, session *crawlSession) (*http.Response, *models.RedirectChain, int, error) {
+	noRedirect := *session.client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	chain := &models.RedirectChain{StartURL: targetURL, Hops: []models.RedirectHop{}, Issues: []string{}}
+	seen := make(map[string]bool)
+	current := targetURL
+	attempts := 0
+
+	for {
+		if seen[current] {
+			chain.AddIssue(RedirectIssueLoop)
+			return nil, chain, attempts, categorize(models.ErrorRedirect, fmt.Errorf("redirect loop detected: %s", chain.Describe()))
+		}
+		seen[current] = true
+
+		if len(chain.Hops) >= maxRedirects {
+			chain.AddIssue(RedirectIssueTooMany)
+			return nil, chain, attempts, categorize(models.ErrorRedirect, fmt.Errorf("stopped after %d redirects: %s", maxRedirects, chain.Describe()))
+		}
+
+		// Redirect targets are subject to robots.txt like any other URL
+		if len(chain.Hops) > 0 {
+			allowed, err := cs.checkRobots(ctx, current, session)
+			if err != nil {
+				return nil, chain, attempts, err
+			}
+			if !allowed {
+				return nil, chain, attempts, categorize(models.ErrorRobotsDisallowed, fmt.Errorf("redirect target %s %s", current, robotsDisallowedReason))
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, current, nil)
+		if err != nil {
+			return nil, chain, attempts, fmt.Errorf("failed to create request: %v", err)
+		}
+
+		start := time.Now()
+		resp, tries, err := session.settings.retry.do(&noRedirect, req)
+		attempts = tries
+		if err != nil {
+			category := requestErrorCategory(err)
+			if attempts > 1 {
+				return nil, chain, attempts, categorize(category, fmt.Errorf("failed to fetch URL after %d attempts: %v", attempts, err))
+			}
+			return nil, chain, attempts, categorize(category, fmt.Errorf("failed to fetch URL: %v", err))
+		}
+		latency := time.Since(start)
+
+		if !isRedirect(resp.StatusCode) {
+			chain.FinalURL = current
+			chain.FinalStatus = resp.StatusCode
+			if len(chain.Hops) >= longRedirectChain {
+				chain.AddIssue(RedirectIssueLongChain)
+			}
+			return resp, chain, attempts, nil
+		}
+
+		location := resp.Header.Get("Location")
+		resp.Body.Close()
+		chain.Hops = append(chain.Hops, models.RedirectHop{
+			URL:        current,
+			StatusCode: resp.StatusCode,
+			Location:   location,
+			LatencyMs:  latency.Milliseconds(),
+		})
+		if location == "" {
+			return nil, chain, attempts, categorize(models.ErrorRedirect, fmt.Errorf("HTTP %d redirect without a Location header", resp.StatusCode))
+		}
+
+		next := resolveLink(location, current)
+		if next == "" {
+			return nil, chain, attempts, categorize(models.ErrorRedirect, fmt.Errorf("invalid redirect Location %q", location))
+		}
+		if strings.HasPrefix(current, "https:") && strings.HasPrefix(next, "http:") {
+			chain.AddIssue(RedirectIssueHTTPSDowngrade)
+		}
+		if (resp.StatusCode == http.StatusFound || resp.StatusCode == http.StatusTemporaryRedirect) && isCanonicalRedirect(current, next) {
+			chain.AddIssue(RedirectIssueTemporary)
+		}
+		current = next
+	}
+}
+
+// isCanonicalRedirect reports whether a redirect only canonicalizes the URL:
+// switching to HTTPS, adding or removing "www." or a trailing slash, or
+// changing case. Such moves are permanent and deserve a 301 or 308.
+func isCanonicalRedirect(from, to string) bool {
+	fromURL, err := url.Parse(from)
+	if err != nil {
+		return false
+	}
+	toURL, err := url.Parse(to)
+	if err != nil {
+		return false
+	}
+
+	canonical := func(u *url.URL) string {
+		host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		path := strings.TrimSuffix(strings.ToLower(u.EscapedPath()), "/")
+		return host + path + "?" + u.RawQuery
+	}
+	return canonical(fromURL) == canonical(toURL)
+}
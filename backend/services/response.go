@@ -0,0 +1,103 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"webcrawler/models"
+)
+
+// sniffLength is how much of a body is inspected when the server sends no
+// Content-Type
+const sniffLength = 512
+
+// htmlMediaTypes are the content types parsed as HTML pages
+var htmlMediaTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+}
+
+// errBodyTooLarge is returned when reading more of a body than allowed
+var errBodyTooLarge = errors.New("response body too large")
+
+// sizeLimitedReader fails with errBodyTooLarge once more than the limit is read
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	// Read one byte past the limit to tell a body of exactly the limit from
+	// a larger one
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// parseHTMLResponse parses a page response as HTML, transcoded to UTF-8 from
+// the charset given by the Content-Type header, a byte order mark or a
+// <meta charset>. Bodies larger than maxBodySize fail with ErrorBodyTooLarge
+// and responses that aren't HTML with ErrorContentType. It also returns the
+// response's media type.
+func parseHTMLResponse(resp *http.Response, maxBodySize int64) (*html.Node, string, error) {
+	if resp.ContentLength > maxBodySize {
+		return nil, "", categorize(models.ErrorBodyTooLarge,
+			fmt.Errorf("response body of %d bytes exceeds the limit of %d bytes", resp.ContentLength, maxBodySize))
+	}
+	body := bufio.NewReader(&sizeLimitedReader{r: resp.Body, remaining: maxBodySize})
+
+	contentType := resp.Header.Get("Content-Type")
+	// Only a charset the server declared decides the encoding; the sniffed
+	// type always claims UTF-8 and would hide <meta charset> and the BOM
+	declared := contentType
+	if contentType == "" {
+		sniffed, _ := body.Peek(sniffLength)
+		contentType = http.DetectContentType(sniffed)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	if !htmlMediaTypes[mediaType] {
+		return nil, mediaType, categorize(models.ErrorContentType, fmt.Errorf("not an HTML page: %s", mediaType))
+	}
+
+	if declared == "" {
+		declared = mediaType
+	}
+	reader, err := charset.NewReader(body, declared)
+	if err == nil {
+		var doc *html.Node
+		if doc, err = html.Parse(reader); err == nil {
+			return doc, mediaType, nil
+		}
+	}
+
+	if errors.Is(err, errBodyTooLarge) {
+		return nil, mediaType, categorize(models.ErrorBodyTooLarge,
+			fmt.Errorf("response body exceeds the limit of %d bytes", maxBodySize))
+	}
+	// The parser only fails when reading the body fails
+	category := requestErrorCategory(err)
+	if category == models.ErrorOther {
+		category = models.ErrorParse
+	}
+	return nil, mediaType, categorize(category, fmt.Errorf("failed to parse HTML: %v", err))
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+
+	"webcrawler/models"
+)
+
+// testResponse builds a response with the given Content-Type and body. A
+// negative contentLength leaves the length unknown.
+func testResponse(contentType, body string, contentLength int64) *http.Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: contentLength,
+	}
+}
+
+// pageTitle returns the text of the document's <title>
+func pageTitle(n *html.Node) string {
+	if n.Type == html.ElementNode && n.Data == "title" {
+		return textContent(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if title := pageTitle(c); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+func TestParseHTMLResponseBodySize(t *testing.T) {
+	const limit = 2048
+	page := func(size int) string {
+		prefix := "<html><head><title>Sized</title></head><body>"
+		return prefix + strings.Repeat("x", size-len(prefix))
+	}
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantCategory  string
+	}{
+		{"below the limit", page(limit - 1), -1, ""},
+		{"exactly the limit", page(limit), -1, ""},
+		{"exactly the limit with length", page(limit), limit, ""},
+		{"one byte over", page(limit + 1), -1, models.ErrorBodyTooLarge},
+		{"far over", page(10 * limit), -1, models.ErrorBodyTooLarge},
+		{"length over the limit", page(limit), limit + 1, models.ErrorBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, _, err := parseHTMLResponse(testResponse("text/html", tt.body, tt.contentLength), limit)
+			if tt.wantCategory == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want none", err)
+				}
+				if title := pageTitle(doc); title != "Sized" {
+					t.Errorf("title = %q, want %q", title, "Sized")
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("err = nil, want an error")
+			}
+			if category := errorCategory(err); category != tt.wantCategory {
+				t.Errorf("category = %q, want %q", category, tt.wantCategory)
+			}
+		})
+	}
+}
+
+func TestParseHTMLResponseContentType(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		body          string
+		wantMediaType string
+		wantCategory  string
+	}{
+		{"html", "text/html; charset=utf-8", "<title>t</title>", "text/html", ""},
+		{"xhtml", "application/xhtml+xml", "<title>t</title>", "application/xhtml+xml", ""},
+		{"uppercase", "TEXT/HTML", "<title>t</title>", "text/html", ""},
+		{"malformed parameters", "text/html; charset", "<title>t</title>", "text/html", ""},
+		{"sniffed html", "", "<!DOCTYPE html><title>t</title>", "text/html", ""},
+		{"pdf", "application/pdf", "%PDF-1.4", "application/pdf", models.ErrorContentType},
+		{"json", "application/json", `{"a":1}`, "application/json", models.ErrorContentType},
+		{"sniffed binary", "", "\x00\x01\x02\x03", "application/octet-stream", models.ErrorContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, mediaType, err := parseHTMLResponse(testResponse(tt.contentType, tt.body, -1), 1024*1024)
+			if mediaType != tt.wantMediaType {
+				t.Errorf("media type = %q, want %q", mediaType, tt.wantMediaType)
+			}
+			if err == nil {
+				if tt.wantCategory != "" {
+					t.Errorf("err = nil, want category %q", tt.wantCategory)
+				}
+				return
+			}
+			if category := errorCategory(err); category != tt.wantCategory {
+				t.Errorf("err = %v (%s), want category %q", err, category, tt.wantCategory)
+			}
+		})
+	}
+}
+
+func TestParseHTMLResponseCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantTitle   string
+	}{
+		{"utf-8", "text/html; charset=utf-8", "<title>Caf\xc3\xa9</title>", "Café"},
+		{"iso-8859-1 header", "text/html; charset=ISO-8859-1", "<title>Caf\xe9 cr\xe8me</title>", "Café crème"},
+		{"latin1 alias", "text/html; charset=latin1", "<title>Se\xf1or</title>", "Señor"},
+		{"iso-8859-1 meta", "text/html", `<meta charset="iso-8859-1"><title>Caf` + "\xe9</title>", "Café"},
+		{"http-equiv meta", "text/html",
+			`<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1"><title>na` + "\xefve</title>", "naïve"},
+		{"windows-1252 quotes", "text/html; charset=windows-1252", "<title>\x93quoted\x94</title>", "“quoted”"},
+		{"utf-8 bom over header", "text/html; charset=iso-8859-1", "\xef\xbb\xbf<title>Caf\xc3\xa9</title>", "Café"},
+		{"shift_jis", "text/html; charset=Shift_JIS", "<title>\x93\xfa\x96{</title>", "日本"},
+		{"unknown charset", "text/html; charset=x-unknown", "<title>plain</title>", "plain"},
+		{"no header with meta", "", `<!DOCTYPE html><meta charset="iso-8859-1"><title>Caf` + "\xe9</title>", "Café"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, _, err := parseHTMLResponse(testResponse(tt.contentType, tt.body, -1), 1024*1024)
+			if err != nil {
+				t.Fatalf("err = %v, want none", err)
+			}
+			if title := pageTitle(doc); title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", title, tt.wantTitle)
+			}
+		})
+	}
+}
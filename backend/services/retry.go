@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"webcrawler/models"
+)
+
+// maxDrainedBody is how much of a failed response is read before retrying so
+// the connection can be reused
+const maxDrainedBody = 64 * 1024
+
+// retryPolicy decides whether and when a failed request is sent again
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	retryOn     map[string]bool
+}
+
+// retryClassSet turns a list of retry classes into a set
+func retryClassSet(classes []string) map[string]bool {
+	set := make(map[string]bool, len(classes))
+	for _, class := range classes {
+		set[class] = true
+	}
+	return set
+}
+
+// newRetryPolicy applies a crawl's retry options over the default policy
+func newRetryPolicy(defaults retryPolicy, options *models.RetryOptions) *retryPolicy {
+	policy := defaults
+	if options == nil {
+		return &policy
+	}
+	if options.MaxAttempts > 0 {
+		policy.maxAttempts = options.MaxAttempts
+	}
+	if options.BaseDelayMs > 0 {
+		policy.baseDelay = time.Duration(options.BaseDelayMs) * time.Millisecond
+	}
+	if options.MaxDelayMs > 0 {
+		policy.maxDelay = time.Duration(options.MaxDelayMs) * time.Millisecond
+	}
+	if len(options.RetryOn) > 0 {
+		policy.retryOn = retryClassSet(options.RetryOn)
+	}
+	return &policy
+}
+
+// failureClass returns the retry class of a request's outcome, or "" when it
+// succeeded or failed for good
+func failureClass(resp *http.Response, err error) string {
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, context.Canceled):
+			return ""
+		case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+			return models.RetryTimeout
+		case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+			errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return models.RetryConnectionReset
+		}
+		return ""
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return strconv.Itoa(resp.StatusCode)
+	}
+	return ""
+}
+
+// backoff returns the delay before a retry (1 for the first): the base delay
+// doubled for every earlier retry, capped, of which a random half is dropped
+// so that crawls failing together don't retry in lockstep
+func (p *retryPolicy) backoff(retry int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < retry && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// drainBody discards what is left of a response body and closes it, so that
+// its connection can be reused
+func drainBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainedBody))
+	resp.Body.Close()
+}
+
+// do sends a request, retrying it after transient failures. It returns the
+// outcome of the last attempt and the number of attempts made.
+func (p *retryPolicy) do(client *http.Client, req *http.Request) (*http.Response, int, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		class := failureClass(resp, err)
+		if class == "" || !p.retryOn[class] || attempt >= p.maxAttempts || ctx.Err() != nil {
+			return resp, attempt, err
+		}
+		if resp != nil {
+			drainBody(resp)
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempt, ctx.Err()
+		}
+	}
+}
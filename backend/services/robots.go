@@ -0,0 +1,365 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webcrawler/models"
+)
+
+const (
+	// robotsCacheTTL is how long a fetched robots.txt stays valid
+	robotsCacheTTL = 24 * time.Hour
+	// robotsErrorTTL is how long the outcome of a failed fetch is kept
+	// before robots.txt is fetched again
+	robotsErrorTTL = 5 * time.Minute
+	// robotsCacheSize caps the number of hosts whose robots.txt is cached
+	robotsCacheSize = 10000
+	// robotsMaxSize is the number of bytes of robots.txt that are parsed
+	robotsMaxSize = 500 * 1024
+	// maxCrawlDelay caps the Crawl-delay a site can impose on us
+	maxCrawlDelay = 30 * time.Second
+)
+
+// robotsRule is a single Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsGroup is a set of rules that applies to one or more user-agents
+type robotsGroup struct {
+	userAgents []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsRules is the parsed robots.txt of a single host
+type robotsRules struct {
+	groups   []*robotsGroup
+	sitemaps []string
+	// disallowAll is set when robots.txt could not be retrieved because the
+	// server failed, in which case the whole host is treated as off limits
+	disallowAll bool
+}
+
+// parseRobots parses the contents of a robots.txt file
+func parseRobots(r io.Reader) *robotsRules {
+	rules := &robotsRules{}
+	var current *robotsGroup
+	// A group ends when a rule line follows the user-agent lines
+	inUserAgents := false
+
+	scanner := bufio.NewScanner(io.LimitReader(r, robotsMaxSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inUserAgents {
+				current = &robotsGroup{}
+				rules.groups = append(rules.groups, current)
+				inUserAgents = true
+			}
+			current.userAgents = append(current.userAgents, strings.ToLower(value))
+
+		case "allow", "disallow":
+			inUserAgents = false
+			if current == nil || value == "" {
+				// An empty Disallow allows everything
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value})
+
+		case "crawl-delay":
+			inUserAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+
+		case "sitemap":
+			rules.sitemaps = append(rules.sitemaps, value)
+		}
+	}
+
+	return rules
+}
+
+// groupsFor returns the groups matching the user-agent. The most specific
+// matching user-agent wins; groups naming the same user-agent are merged.
+// Groups for "*" are used when nothing more specific matches.
+func (r *robotsRules) groupsFor(userAgent string) []*robotsGroup {
+	token := strings.ToLower(userAgent)
+	if i := strings.Index(token, "/"); i >= 0 {
+		token = token[:i]
+	}
+
+	var matched, wildcard []*robotsGroup
+	bestLength := 0
+	for _, group := range r.groups {
+		for _, agent := range group.userAgents {
+			if agent == "*" {
+				wildcard = append(wildcard, group)
+				continue
+			}
+			if !strings.Contains(token, agent) {
+				continue
+			}
+			if len(agent) > bestLength {
+				bestLength = len(agent)
+				matched = []*robotsGroup{group}
+			} else if len(agent) == bestLength {
+				matched = append(matched, group)
+			}
+		}
+	}
+
+	if len(matched) > 0 {
+		return matched
+	}
+	return wildcard
+}
+
+// Allowed reports whether the user-agent may fetch the URL. The longest
+// matching pattern decides; Allow wins over Disallow on a tie.
+func (r *robotsRules) Allowed(userAgent string, target *url.URL) bool {
+	if r.disallowAll {
+		return false
+	}
+
+	path := target.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if target.RawQuery != "" {
+		path += "?" + target.RawQuery
+	}
+
+	allowed := true
+	bestLength := -1
+	for _, group := range r.groupsFor(userAgent) {
+		for _, rule := range group.rules {
+			if !robotsPatternMatches(rule.pattern, path) {
+				continue
+			}
+			length := len(rule.pattern)
+			if length > bestLength || (length == bestLength && rule.allow) {
+				bestLength = length
+				allowed = rule.allow
+			}
+		}
+	}
+
+	return allowed
+}
+
+// CrawlDelay returns the Crawl-delay that applies to the user-agent
+func (r *robotsRules) CrawlDelay(userAgent string) time.Duration {
+	var delay time.Duration
+	for _, group := range r.groupsFor(userAgent) {
+		if group.crawlDelay > delay {
+			delay = group.crawlDelay
+		}
+	}
+	if delay > maxCrawlDelay {
+		delay = maxCrawlDelay
+	}
+	return delay
+}
+
+// robotsPatternMatches matches a robots.txt path pattern supporting the "*"
+// wildcard and the "$" end anchor
+func robotsPatternMatches(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+
+	for i := 1; i < len(parts); i++ {
+		part := parts[i]
+		if i == len(parts)-1 && anchored {
+			// The last segment must sit at the very end of the path
+			return strings.HasSuffix(path[pos:], part)
+		}
+		idx := strings.Index(path[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+
+	if anchored {
+		return pos == len(path)
+	}
+	return true
+}
+
+// robotsEntry is a cached robots.txt together with its expiry
+type robotsEntry struct {
+	rules     *robotsRules
+	expiresAt time.Time
+}
+
+// robotsCache fetches robots.txt once per host for every crawl running in
+// the process
+type robotsCache struct {
+	entries map[string]*robotsEntry
+	mutex   sync.Mutex
+}
+
+func newRobotsCache() *robotsCache {
+	return &robotsCache{
+		entries: make(map[string]*robotsEntry),
+	}
+}
+
+// robotsHostKey identifies the robots.txt responsible for a URL
+func robotsHostKey(target *url.URL) string {
+	return strings.ToLower(target.Scheme + "://" + target.Host)
+}
+
+// get returns the robots.txt rules for the URL's host, fetching them when
+// they are not cached or have expired
+func (rc *robotsCache) get(ctx context.Context, target *url.URL, client *http.Client) *robotsRules {
+	key := robotsHostKey(target)
+
+	rc.mutex.Lock()
+	entry, ok := rc.entries[key]
+	rc.mutex.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.rules
+	}
+
+	rules, ttl := fetchRobots(ctx, key, client)
+	if ctx.Err() != nil {
+		// Don't cache the outcome of an aborted fetch
+		return rules
+	}
+	rc.store(key, rules, ttl)
+	return rules
+}
+
+// store caches the rules of a host. When the cache is full the expired
+// entries are dropped first, then the one closest to expiring.
+func (rc *robotsCache) store(key string, rules *robotsRules, ttl time.Duration) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	now := time.Now()
+	if _, ok := rc.entries[key]; !ok && len(rc.entries) >= robotsCacheSize {
+		for host, entry := range rc.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(rc.entries, host)
+			}
+		}
+	}
+	if _, ok := rc.entries[key]; !ok && len(rc.entries) >= robotsCacheSize {
+		var oldest string
+		for host, entry := range rc.entries {
+			if oldest == "" || entry.expiresAt.Before(rc.entries[oldest].expiresAt) {
+				oldest = host
+			}
+		}
+		delete(rc.entries, oldest)
+	}
+	rc.entries[key] = &robotsEntry{rules: rules, expiresAt: now.Add(ttl)}
+}
+
+// fetchRobots downloads and parses robots.txt and returns how long the
+// outcome may be cached. A missing file (4xx) allows everything and server
+// errors disallow everything. When the host cannot be reached at all,
+// everything is allowed so the fetch itself reports the error. Failures are
+// only cached briefly so robots.txt is retried.
+func fetchRobots(ctx context.Context, hostKey string, client *http.Client) (*robotsRules, time.Duration) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hostKey+"/robots.txt", nil)
+	if err != nil {
+		return &robotsRules{}, robotsErrorTTL
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &robotsRules{}, robotsErrorTTL
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return parseRobots(resp.Body), robotsCacheTTL
+	case resp.StatusCode >= 500:
+		return &robotsRules{disallowAll: true}, robotsErrorTTL
+	default:
+		return &robotsRules{}, robotsCacheTTL
+	}
+}
+
+// newRobotsClient returns a client that fetches robots.txt with the server's
+// default user-agent, headers and proxy only. Its requests are scheduled per
+// host like the crawl's own.
+func (cs *CrawlerService) newRobotsClient(hosts *requestHosts) (*http.Client, error) {
+	settings, err := cs.resolveSettings(&models.CrawlOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &sessionTransport{
+			base:      cs.transport,
+			settings:  settings,
+			scheduler: cs.hosts,
+			hosts:     hosts,
+			toSite: func(*http.Request) bool {
+				return false
+			},
+		},
+	}, nil
+}
+
+// robotsDisallowedReason is recorded for URLs skipped because of robots.txt
+const robotsDisallowedReason = "disallowed by robots.txt"
+
+// checkRobots reports whether the URL may be fetched with the crawl's
+// user-agent. The host's Crawl-delay is handed to the host scheduler, which
+// spaces out the requests of every crawl.
+func (cs *CrawlerService) checkRobots(ctx context.Context, target string, session *crawlSession) (bool, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse URL: %v", err)
+	}
+	if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+		return true, nil
+	}
+
+	userAgent := session.settings.userAgent
+	rules := cs.robots.get(ctx, targetURL, session.robotsClient)
+	if !rules.Allowed(userAgent, targetURL) {
+		return false, nil
+	}
+
+	cs.hosts.setCrawlDelay(targetURL.Host, rules.CrawlDelay(userAgent))
+	return true, nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/", true},
+		{"/private", "/private/page", true},
+		{"/private", "/public", false},
+		{"/*.php", "/index.php", true},
+		{"/*.php", "/dir/index.php?x=1", true},
+		{"/*.php", "/index.html", false},
+		{"/*.php$", "/index.php", true},
+		{"/*.php$", "/index.php?x=1", false},
+		{"/*.php$", "/index.phpx", false},
+		{"/page$", "/page", true},
+		{"/page$", "/page/", false},
+		{"/a*b*c", "/axxbyyc", true},
+		{"/a*b*c", "/axxcyyb", false},
+		{"/a*b*c$", "/abcabc", true},
+		{"/a*b*c$", "/abcab", false},
+		{"*/admin", "/site/admin", true},
+		{"/*?*sort=", "/list?page=2&sort=asc", true},
+		{"/*?*sort=", "/list/sort=asc", false},
+		{"$", "", true},
+		{"$", "/", false},
+	}
+	for _, tt := range tests {
+		if got := robotsPatternMatches(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsPatternMatches(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	rules := parseRobots(strings.NewReader(`
+# Comments and unknown lines are ignored
+User-agent: *
+Disallow: /private
+Allow: /private/open
+Disallow: /*.pdf$
+Disallow: /search?
+Crawl-delay: 2
+
+User-agent: WebCrawler
+User-agent: OtherBot
+Disallow: /crawler-only
+Allow: /
+Crawl-delay: 0.5
+
+User-agent: WebCrawler-Extra
+Disallow: /
+
+Sitemap: https://example.com/sitemap.xml
+`))
+
+	tests := []struct {
+		userAgent string
+		path      string
+		want      bool
+	}{
+		{"SomeBot/1.0", "/", true},
+		{"SomeBot/1.0", "/private", false},
+		{"SomeBot/1.0", "/private/page", false},
+		{"SomeBot/1.0", "/private/open/page", true},
+		{"SomeBot/1.0", "/files/report.pdf", false},
+		{"SomeBot/1.0", "/files/report.pdf?download=1", true},
+		{"SomeBot/1.0", "/search?q=go", false},
+		{"SomeBot/1.0", "/search", true},
+		// The most specific group replaces the wildcard group
+		{"WebCrawler/1.0", "/private", true},
+		{"WebCrawler/1.0", "/crawler-only/page", false},
+		{"otherbot", "/crawler-only", false},
+		{"WebCrawler-Extra/2.0", "/", false},
+	}
+	for _, tt := range tests {
+		target, _ := url.Parse("https://example.com" + tt.path)
+		if got := rules.Allowed(tt.userAgent, target); got != tt.want {
+			t.Errorf("Allowed(%q, %q) = %v, want %v", tt.userAgent, tt.path, got, tt.want)
+		}
+	}
+
+	if got := rules.CrawlDelay("SomeBot"); got != 2*time.Second {
+		t.Errorf("CrawlDelay(SomeBot) = %v, want 2s", got)
+	}
+	if got := rules.CrawlDelay("WebCrawler"); got != 500*time.Millisecond {
+		t.Errorf("CrawlDelay(WebCrawler) = %v, want 500ms", got)
+	}
+	if len(rules.sitemaps) != 1 || rules.sitemaps[0] != "https://example.com/sitemap.xml" {
+		t.Errorf("sitemaps = %v, want the one listed", rules.sitemaps)
+	}
+}
+
+func TestRobotsCrawlDelayCapped(t *testing.T) {
+	rules := parseRobots(strings.NewReader("User-agent: *\nCrawl-delay: 3600\n"))
+	if got := rules.CrawlDelay("bot"); got != maxCrawlDelay {
+		t.Errorf("CrawlDelay = %v, want the cap %v", got, maxCrawlDelay)
+	}
+}
+
+func TestFetchRobots(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantAllowed bool
+		wantTTL     time.Duration
+	}{
+		{"found", http.StatusOK, "User-agent: *\nDisallow: /page\n", false, robotsCacheTTL},
+		{"missing", http.StatusNotFound, "", true, robotsCacheTTL},
+		{"forbidden", http.StatusForbidden, "", true, robotsCacheTTL},
+		{"server error", http.StatusServiceUnavailable, "", false, robotsErrorTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			rules, ttl := fetchRobots(context.Background(), server.URL, server.Client())
+			target, _ := url.Parse(server.URL + "/page")
+			if got := rules.Allowed("bot", target); got != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", got, tt.wantAllowed)
+			}
+			if ttl != tt.wantTTL {
+				t.Errorf("ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		rules, ttl := fetchRobots(context.Background(), server.URL, http.DefaultClient)
+		target, _ := url.Parse(server.URL + "/page")
+		if !rules.Allowed("bot", target) {
+			t.Error("Allowed = false, want true so the page fetch reports the error")
+		}
+		if ttl != robotsErrorTTL {
+			t.Errorf("ttl = %v, want %v", ttl, robotsErrorTTL)
+		}
+	})
+}
+
+func TestRobotsCacheRetriesFailures(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cache := newRobotsCache()
+	target, _ := url.Parse(server.URL + "/page")
+	cache.get(context.Background(), target, server.Client())
+	cache.get(context.Background(), target, server.Client())
+	if requests != 1 {
+		t.Fatalf("requests = %d, want 1 while the failure is cached", requests)
+	}
+
+	// Expire the failure as if robotsErrorTTL had passed
+	cache.entries[robotsHostKey(target)].expiresAt = time.Now().Add(-time.Second)
+	cache.get(context.Background(), target, server.Client())
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2 once the failure expired", requests)
+	}
+}
+
+func TestRobotsCacheStoreBounded(t *testing.T) {
+	cache := newRobotsCache()
+	for i := 0; i < robotsCacheSize; i++ {
+		cache.store(fmt.Sprintf("https://host%d.example", i), &robotsRules{}, robotsCacheTTL)
+	}
+	// An expired entry is dropped before any live one
+	cache.entries["https://host1.example"].expiresAt = time.Now().Add(-time.Second)
+	cache.store("https://new.example", &robotsRules{}, robotsCacheTTL)
+	if len(cache.entries) != robotsCacheSize {
+		t.Fatalf("len = %d, want %d", len(cache.entries), robotsCacheSize)
+	}
+	if _, ok := cache.entries["https://host1.example"]; ok {
+		t.Error("expired entry was kept")
+	}
+
+	// Without expired entries the one closest to expiring goes
+	cache.store("https://short.example", &robotsRules{}, robotsErrorTTL)
+	cache.store("https://newer.example", &robotsRules{}, robotsCacheTTL)
+	if len(cache.entries) != robotsCacheSize {
+		t.Fatalf("len = %d, want %d", len(cache.entries), robotsCacheSize)
+	}
+	if _, ok := cache.entries["https://short.example"]; ok {
+		t.Error("entry closest to expiring was kept")
+	}
+	if _, ok := cache.entries["https://newer.example"]; !ok {
+		t.Error("new entry was not stored")
+	}
+}
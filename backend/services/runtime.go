@@ -0,0 +1,100 @@
+package services
+
+import (
+	"log"
+	"net/url"
+	"time"
+
+	"webcrawler/config"
+	"webcrawler/models"
+)
+
+// runtimeSettings are the crawler defaults that can change while the server
+// runs. Crawls take a snapshot of them when they start. A nil proxy leaves
+// the choice to the proxy environment variables.
+type runtimeSettings struct {
+	maxConcurrentCrawls int
+	crawlTimeout        time.Duration
+	maxDepth            int
+	maxPagesPerDomain   int
+	userAgent           string
+	headers             map[string]string
+	proxy               *url.URL
+	maxBodySize         int64
+	retry               retryPolicy
+}
+
+// ApplyConfig updates the runtime settings from a (reloaded) config and
+// resizes the worker pool. Running crawls keep the settings they started with.
+func (cs *CrawlerService) ApplyConfig(cfg *config.Config) {
+	var proxy *url.URL
+	if cfg.ProxyURL != "" {
+		// The config was validated when it was loaded
+		proxy, _ = models.ParseProxyURL(cfg.ProxyURL)
+	}
+
+	cs.runtimeMutex.Lock()
+	cs.runtime = runtimeSettings{
+		maxConcurrentCrawls: cfg.MaxConcurrentCrawls,
+		crawlTimeout:        time.Duration(cfg.CrawlTimeout) * time.Second,
+		maxDepth:            cfg.MaxDepth,
+		maxPagesPerDomain:   cfg.MaxPagesPerDomain,
+		userAgent:           cfg.UserAgent,
+		headers:             cfg.Headers(),
+		proxy:               proxy,
+		maxBodySize:         cfg.MaxBodySize,
+		retry: retryPolicy{
+			maxAttempts: cfg.RetryMaxAttempts,
+			baseDelay:   time.Duration(cfg.RetryBaseDelay) * time.Millisecond,
+			maxDelay:    time.Duration(cfg.RetryMaxDelay) * time.Millisecond,
+			retryOn:     retryClassSet(cfg.RetryOn),
+		},
+	}
+	cs.runtimeMutex.Unlock()
+
+	cs.hosts.configure(cfg.HostRequestsPerSecond, cfg.HostMaxConnections)
+	cs.hosts.configureThrottle(cfg.AutoThrottle, time.Duration(cfg.AutoThrottleMaxDelay)*time.Second)
+
+	cs.resizeWorkers()
+}
+
+// currentSettings returns a copy of the current runtime settings
+func (cs *CrawlerService) currentSettings() runtimeSettings {
+	cs.runtimeMutex.RLock()
+	defer cs.runtimeMutex.RUnlock()
+	return cs.runtime
+}
+
+// resizeWorkers starts workers until the pool matches the concurrency limit.
+// Surplus workers retire once they are idle, so no running crawl is cut short.
+func (cs *CrawlerService) resizeWorkers() {
+	cs.runtimeMutex.Lock()
+	defer cs.runtimeMutex.Unlock()
+	if !cs.workersStarted {
+		return
+	}
+
+	started := 0
+	for cs.workers < cs.runtime.maxConcurrentCrawls {
+		cs.workers++
+		started++
+		go cs.worker()
+	}
+	if started > 0 {
+		log.Printf("Started %d crawl workers (%d total)", started, cs.workers)
+	} else if cs.workers > cs.runtime.maxConcurrentCrawls {
+		log.Printf("Retiring %d idle crawl workers", cs.workers-cs.runtime.maxConcurrentCrawls)
+	}
+}
+
+// retireWorker removes the calling worker from the pool when the pool is
+// larger than the concurrency limit and reports whether it did
+func (cs *CrawlerService) retireWorker() bool {
+	cs.runtimeMutex.Lock()
+	defer cs.runtimeMutex.Unlock()
+	if cs.workers <= cs.runtime.maxConcurrentCrawls {
+		return false
+	}
+	cs.workers--
+	return true
+}
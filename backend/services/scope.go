@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"webcrawler/models"
+)
+
+// scopePattern is a compiled include or exclude pattern
+type scopePattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// logoutPattern matches the paths of typical logout links
+var logoutPattern = regexp.MustCompile(`(?i)(log|sign)[-_]?(out|off)`)
+
+// scopeRules decides which URLs a crawl may follow and check
+type scopeRules struct {
+	include            []scopePattern
+	exclude            []scopePattern
+	allowedHosts       []string
+	excludedExtensions map[string]bool
+	// keepSession skips logout links so a logged-in crawl stays logged in
+	keepSession bool
+}
+
+// newScopeRules compiles the crawl's scope options. Without options every
+// URL is in scope.
+func newScopeRules(options *models.ScopeOptions) (*scopeRules, error) {
+	rules := &scopeRules{excludedExtensions: make(map[string]bool)}
+	if options == nil {
+		return rules, nil
+	}
+
+	for _, pattern := range options.Include {
+		re, err := models.CompileScopePattern(pattern, options.PatternType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %v", pattern, err)
+		}
+		rules.include = append(rules.include, scopePattern{source: pattern, re: re})
+	}
+	for _, pattern := range options.Exclude {
+		re, err := models.CompileScopePattern(pattern, options.PatternType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %v", pattern, err)
+		}
+		rules.exclude = append(rules.exclude, scopePattern{source: pattern, re: re})
+	}
+	for _, host := range options.AllowedHosts {
+		rules.allowedHosts = append(rules.allowedHosts, strings.ToLower(host))
+	}
+	for _, ext := range options.ExcludeExtensions {
+		rules.excludedExtensions["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return rules, nil
+}
+
+// check returns "" when the URL is in scope, or the reason it is not
+func (r *scopeRules) check(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	if len(r.allowedHosts) > 0 && !r.hostAllowed(u) {
+		return "host not in allowed hosts"
+	}
+
+	if r.keepSession && logoutPattern.MatchString(u.Path) {
+		return "logout link skipped to keep the login session"
+	}
+
+	if ext := strings.ToLower(path.Ext(u.Path)); ext != "" && r.excludedExtensions[ext] {
+		return fmt.Sprintf("excluded file extension %s", ext)
+	}
+
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	for _, pattern := range r.exclude {
+		if pattern.re.MatchString(target) {
+			return fmt.Sprintf("excluded by scope pattern %s", pattern.source)
+		}
+	}
+	if len(r.include) == 0 {
+		return ""
+	}
+	for _, pattern := range r.include {
+		if pattern.re.MatchString(target) {
+			return ""
+		}
+	}
+	return "not matched by any include pattern"
+}
+
+// hostAllowed reports whether the URL's host is one of the allowed hosts
+func (r *scopeRules) hostAllowed(u *url.URL) bool {
+	hostname := normalizeHostname(u.Hostname())
+	host := strings.ToLower(u.Host)
+	for _, allowed := range r.allowedHosts {
+		if suffix, wildcard := strings.CutPrefix(allowed, "*."); wildcard {
+			if hostname == suffix || strings.HasSuffix(hostname, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if hostname == allowed || host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviewScope fetches the seed page and reports which of the URLs it links
+// to a crawl with the given options would follow or check
+func (cs *CrawlerService) PreviewScope(ctx context.Context, targetURL string, options *models.CrawlOptions) (*models.ScopePreview, error) {
+	session, err := cs.newCrawlSession(&models.CrawlResult{URL: targetURL, Options: options})
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := cs.checkRobots(ctx, targetURL, session)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, categorize(models.ErrorRobotsDisallowed, fmt.Errorf("URL %s", robotsDisallowedReason))
+	}
+
+	if err := cs.login(ctx, session); err != nil {
+		return nil, err
+	}
+	crawlData, err := cs.crawlURL(ctx, targetURL, session)
+	if err != nil {
+		return nil, err
+	}
+	session.classifier.addSite(crawlData.FinalURL)
+	session.normalizer.normalizeLinks(crawlData)
+
+	preview := &models.ScopePreview{
+		URL:        crawlData.FinalURL,
+		InScope:    []models.ScopeDecision{},
+		OutOfScope: []models.ScopeDecision{},
+	}
+	seen := make(map[string]bool)
+	for _, ref := range crawlData.LinkRefs {
+		key := session.normalizer.Key(ref.URL)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		linkURL, err := url.Parse(ref.URL)
+		if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https") {
+			continue
+		}
+		decision := models.ScopeDecision{
+			URL:      ref.URL,
+			Element:  ref.Element,
+			Internal: session.classifier.isInternal(linkURL),
+			Reason:   session.scope.check(ref.URL),
+		}
+		if decision.Reason == "" {
+			preview.InScope = append(preview.InScope, decision)
+		} else {
+			preview.OutOfScope = append(preview.OutOfScope, decision)
+		}
+	}
+
+	return preview, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"webcrawler/models"
+)
+
+// crawlSettings are the limits and request settings of one crawl, resolved
+// from its options and the service defaults when the crawl starts
+type crawlSettings struct {
+	timeout           time.Duration
+	maxDepth          int
+	maxPagesPerDomain int
+	userAgent         string
+	headers           map[string]string
+	siteHeaders       map[string]string
+	cookies           map[string]string
+	followExternal    bool
+	maxBodySize       int64
+	proxy             *url.URL
+	retry             *retryPolicy
+	// auth holds the decrypted credentials of the crawl, if any
+	auth *models.AuthOptions
+}
+
+// resolveSettings applies a crawl's options over the current service defaults
+func (cs *CrawlerService) resolveSettings(options *models.CrawlOptions) (*crawlSettings, error) {
+	defaults := cs.currentSettings()
+	settings := &crawlSettings{
+		timeout:           defaults.crawlTimeout,
+		maxDepth:          defaults.maxDepth,
+		maxPagesPerDomain: defaults.maxPagesPerDomain,
+		userAgent:         defaults.userAgent,
+		headers:           make(map[string]string, len(defaults.headers)),
+		siteHeaders:       make(map[string]string, len(options.Headers)),
+		cookies:           options.Cookies,
+		followExternal:    options.FollowExternal,
+		maxBodySize:       defaults.maxBodySize,
+		proxy:             defaults.proxy,
+		retry:             newRetryPolicy(defaults.retry, options.Retry),
+	}
+	for name, value := range defaults.headers {
+		settings.headers[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range options.Headers {
+		settings.siteHeaders[http.CanonicalHeaderKey(name)] = value
+	}
+	if options.Timeout > 0 {
+		settings.timeout = time.Duration(options.Timeout) * time.Second
+	}
+	if options.MaxDepth != nil {
+		settings.maxDepth = *options.MaxDepth
+	}
+	if options.MaxPagesPerDomain > 0 {
+		settings.maxPagesPerDomain = options.MaxPagesPerDomain
+	}
+	if options.MaxBodySize > 0 {
+		settings.maxBodySize = options.MaxBodySize
+	}
+	if options.UserAgent != "" {
+		settings.userAgent = options.UserAgent
+	}
+	if options.Proxy != "" {
+		proxy, err := models.ParseProxyURL(options.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		settings.proxy = proxy
+	}
+	return settings, nil
+}
+
+// sessionTransport applies a crawl's user-agent, headers, cookies, credentials
+// and proxy to every request the crawl sends and schedules the requests
+// politely per host
+type sessionTransport struct {
+	base      http.RoundTripper
+	settings  *crawlSettings
+	scheduler *hostScheduler
+	hosts     *requestHosts
+	// digest answers Digest challenges of crawls with digest auth
+	digest *digestAuth
+	// toSite reports whether a request goes to the crawled site, the only
+	// one that gets the crawl's headers, cookies and credentials
+	toSite func(*http.Request) bool
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(withProxy(req.Context(), t.settings.proxy))
+	req.Header.Set("User-Agent", t.settings.userAgent)
+	for name, value := range t.settings.headers {
+		req.Header.Set(name, value)
+	}
+	toSite := t.toSite(req)
+	if toSite {
+		// The crawl's headers replace default headers of the same name
+		for name, value := range t.settings.siteHeaders {
+			req.Header.Set(name, value)
+		}
+		for name, value := range t.settings.cookies {
+			// Cookies the site set in the jar replace the seeded ones
+			if _, err := req.Cookie(name); err != nil {
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+		}
+		t.authorize(req)
+	}
+
+	resp, err := t.send(req)
+	if err != nil || t.digest == nil || !toSite || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// Answer a Digest challenge once; another 401 means the credentials are
+	// wrong. Requests whose body can't be replayed get the 401.
+	if (req.Body != nil && req.GetBody == nil) || !t.digest.challenge(resp) {
+		return resp, nil
+	}
+	drainBody(resp)
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	t.digest.authorize(retry)
+	return t.send(retry)
+}
+
+// send schedules a request on its host and sends it. The request timeout
+// starts once the host's slot is acquired, so waiting behind the host's
+// delays doesn't count against it.
+func (t *sessionTransport) send(req *http.Request) (*http.Response, error) {
+	release, err := t.scheduler.acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	t.hosts.add(req.URL.Host)
+	ctx, cancel := context.WithTimeout(req.Context(), t.settings.timeout)
+	req = req.WithContext(ctx)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		// A cancelled crawl says nothing about the host's health
+		if !errors.Is(req.Context().Err(), context.Canceled) {
+			t.scheduler.adapt(req.URL.Host, time.Since(start), 0, true)
+		}
+		cancel()
+		release()
+		return nil, err
+	}
+	t.scheduler.adapt(req.URL.Host, time.Since(start), resp.StatusCode, false)
+	t.scheduler.observe(req.URL.Host, resp)
+
+	// The connection counts against the host and the timeout keeps running
+	// until the body is closed
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() {
+		cancel()
+		release()
+	}}
+	return resp, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"webcrawler/models"
+)
+
+// errShuttingDown is the cancellation cause of crawls interrupted by shutdown
+var errShuttingDown = errors.New("server is shutting down")
+
+// checkpointTimeout bounds how long interrupted crawls get to save their
+// partial results once the grace period is over
+const checkpointTimeout = 15 * time.Second
+
+// Shutdown stops the worker pool from claiming new crawls and waits for the
+// running ones to finish until ctx expires. Crawls still running after that
+// are cancelled, their partial results are checkpointed and their jobs go
+// back on the queue for the next process to pick up.
+func (cs *CrawlerService) Shutdown(ctx context.Context) error {
+	cs.mutex.Lock()
+	if !cs.shuttingDown {
+		cs.shuttingDown = true
+		close(cs.quit)
+	}
+	cs.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		cs.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("All crawls finished")
+		return nil
+	case <-ctx.Done():
+	}
+
+	cs.mutex.RLock()
+	log.Printf("Grace period over, checkpointing %d running crawls", len(cs.activeCrawls))
+	for _, cancel := range cs.activeCrawls {
+		cancel(errShuttingDown)
+	}
+	cs.mutex.RUnlock()
+
+	select {
+	case <-done:
+	case <-time.After(checkpointTimeout):
+		log.Println("Timed out waiting for crawls to checkpoint")
+	}
+	return ctx.Err()
+}
+
+// isShuttingDown reports whether Shutdown has been called
+func (cs *CrawlerService) isShuttingDown() bool {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.shuttingDown
+}
+
+// requeueJob puts a job interrupted by shutdown back on the queue
+func (cs *CrawlerService) requeueJob(job *models.CrawlJob) {
+	if err := cs.db.Model(&models.CrawlJob{}).Where("id = ?", job.ID).
+		Updates(map[string]interface{}{"status": models.JobPending, "heartbeat_at": nil}).Error; err != nil {
+		log.Printf("Failed to requeue crawl job %d: %v", job.ID, err)
+		return
+	}
+	log.Printf("Requeued crawl %s for the next start", job.CrawlResultID)
+}
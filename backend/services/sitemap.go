@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"webcrawler/models"
+)
+
+const (
+	// maxSitemapSize is the largest (uncompressed) sitemap we read, per the protocol
+	maxSitemapSize = 50 * 1024 * 1024
+	// maxSitemapFiles limits how many sitemaps and sitemap indexes one crawl fetches
+	maxSitemapFiles = 50
+	// maxSitemapURLs limits how many sitemap entries one crawl keeps
+	maxSitemapURLs = 50000
+)
+
+// sitemapDocument covers both <urlset> and <sitemapindex> documents
+type sitemapDocument struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc      string `xml:"loc"`
+		LastMod  string `xml:"lastmod"`
+		Priority string `xml:"priority"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// sitemapDateLayouts are the W3C datetime formats allowed for <lastmod>
+var sitemapDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// loadSitemaps discovers the sitemaps of the seed URL's host, records their
+// entries on the session and queues the URLs on the seed host, highest
+// priority first, to be crawled after the pages reachable by links
+func (cs *CrawlerService) loadSitemaps(ctx context.Context, session *crawlSession, seedURL string) {
+	seed, err := url.Parse(seedURL)
+	if err != nil || (seed.Scheme != "http" && seed.Scheme != "https") {
+		return
+	}
+
+	queue := cs.discoverSitemaps(ctx, seed, session)
+	fetched := make(map[string]bool)
+	var crawlable []*models.SitemapURL
+
+	for len(queue) > 0 && len(fetched) < maxSitemapFiles && ctx.Err() == nil {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if fetched[sitemapURL] {
+			continue
+		}
+		fetched[sitemapURL] = true
+
+		doc, err := cs.fetchSitemap(ctx, sitemapURL, session)
+		if err != nil {
+			log.Printf("Failed to read sitemap %s: %v", sitemapURL, err)
+			continue
+		}
+		session.sitemaps = append(session.sitemaps, sitemapURL)
+
+		// Sitemap indexes point to further sitemaps
+		for _, child := range doc.Sitemaps {
+			if loc := strings.TrimSpace(child.Loc); loc != "" {
+				queue = append(queue, loc)
+			}
+		}
+
+		for _, entry := range doc.URLs {
+			if len(session.sitemapURLs) >= maxSitemapURLs {
+				break
+			}
+			loc, err := url.Parse(strings.TrimSpace(entry.Loc))
+			if err != nil || !strings.EqualFold(loc.Host, seed.Host) {
+				continue
+			}
+			key := session.normalizer.Key(loc.String())
+			if _, seen := session.sitemapURLs[key]; seen {
+				continue
+			}
+
+			listed := &models.SitemapURL{
+				URL:       session.normalizer.Normalize(loc.String()),
+				Sitemap:   sitemapURL,
+				InSitemap: true,
+				LastMod:   parseSitemapDate(entry.LastMod),
+			}
+			if priority, err := strconv.ParseFloat(strings.TrimSpace(entry.Priority), 64); err == nil {
+				listed.Priority = &priority
+			}
+			session.sitemapURLs[key] = listed
+			session.sitemapOrder = append(session.sitemapOrder, key)
+			if reason := session.scope.check(listed.URL); reason != "" {
+				session.skip(listed.URL, reason)
+				continue
+			}
+			crawlable = append(crawlable, listed)
+		}
+	}
+
+	// Entries without a priority default to 0.5, per the protocol
+	priority := func(entry *models.SitemapURL) float64 {
+		if entry.Priority == nil {
+			return 0.5
+		}
+		return *entry.Priority
+	}
+	sort.SliceStable(crawlable, func(i, j int) bool {
+		return priority(crawlable[i]) > priority(crawlable[j])
+	})
+	for _, entry := range crawlable {
+		session.enqueueSitemapURL(entry.URL)
+	}
+}
+
+// discoverSitemaps lists the sitemaps announced in robots.txt, falling back
+// to /sitemap.xml on the seed host
+func (cs *CrawlerService) discoverSitemaps(ctx context.Context, seed *url.URL, session *crawlSession) []string {
+	rules := cs.robots.get(ctx, seed, session.robotsClient)
+	sitemaps := append([]string{}, rules.sitemaps...)
+
+	fallback := robotsHostKey(seed) + "/sitemap.xml"
+	for _, sitemap := range sitemaps {
+		if sitemap == fallback {
+			return sitemaps
+		}
+	}
+	return append(sitemaps, fallback)
+}
+
+// fetchSitemap downloads and parses a sitemap or sitemap index, transparently
+// decompressing gzipped sitemaps
+func (cs *CrawlerService) fetchSitemap(ctx context.Context, sitemapURL string, session *crawlSession) (*sitemapDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", session.settings.userAgent)
+
+	resp, err := session.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	// Detect gzip by its magic bytes rather than trusting the URL or headers
+	body := bufio.NewReader(resp.Body)
+	var reader io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress sitemap: %v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	var doc sitemapDocument
+	if err := xml.NewDecoder(io.LimitReader(reader, maxSitemapSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap: %v", err)
+	}
+	if doc.XMLName.Local != "urlset" && doc.XMLName.Local != "sitemapindex" {
+		return nil, fmt.Errorf("unexpected sitemap root element <%s>", doc.XMLName.Local)
+	}
+
+	return &doc, nil
+}
+
+// parseSitemapDate parses a <lastmod> value, returning nil when it is invalid
+func parseSitemapDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range sitemapDateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return &parsed
+		}
+	}
+	return nil
+}
+
+// sitemapResults compares the sitemap entries with the internal links found
+// while crawling. Linked pages missing from the sitemap are only reported
+// when the site has a sitemap at all.
+func (s *crawlSession) sitemapResults() []models.SitemapURL {
+	if len(s.sitemapURLs) == 0 {
+		return nil
+	}
+
+	results := make([]models.SitemapURL, 0, len(s.sitemapOrder))
+	for _, key := range s.sitemapOrder {
+		entry := *s.sitemapURLs[key]
+		_, entry.Linked = s.internalLinks[key]
+		results = append(results, entry)
+	}
+	for key, link := range s.internalLinks {
+		if _, listed := s.sitemapURLs[key]; listed {
+			continue
+		}
+		linkURL, err := url.Parse(link)
+		if err != nil || (linkURL.Scheme != "http" && linkURL.Scheme != "https") {
+			continue
+		}
+		results = append(results, models.SitemapURL{URL: link, Linked: true})
+	}
+
+	return results
+}
+
+// GetSitemapReport compares the sitemap of a crawl with the pages it linked
+func (cs *CrawlerService) GetSitemapReport(id string) (*models.SitemapReport, error) {
+	var crawlResult models.CrawlResult
+	if err := cs.db.First(&crawlResult, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+
+	var entries []models.SitemapURL
+	if err := cs.db.Where("crawl_result_id = ?", id).Order("id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	report := &models.SitemapReport{
+		CrawlResultID: id,
+		Sitemaps:      []string{},
+		OrphanedURLs:  []models.SitemapURL{},
+		UnlistedURLs:  []models.SitemapURL{},
+	}
+	seenSitemaps := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.InSitemap {
+			report.SitemapURLsCount++
+			if !seenSitemaps[entry.Sitemap] {
+				seenSitemaps[entry.Sitemap] = true
+				report.Sitemaps = append(report.Sitemaps, entry.Sitemap)
+			}
+		}
+		switch {
+		case entry.InSitemap && !entry.Linked:
+			report.OrphanedURLs = append(report.OrphanedURLs, entry)
+		case !entry.InSitemap && entry.Linked:
+			report.UnlistedURLs = append(report.UnlistedURLs, entry)
+		}
+	}
+
+	return report, nil
+}
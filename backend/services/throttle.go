@@ -0,0 +1,137 @@
+package services
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"webcrawler/models"
+)
+
+// minThrottleDelay is the first delay a failing host without a rate limit
+// backs off to
+const minThrottleDelay = 250 * time.Millisecond
+
+// configureThrottle turns adaptive throttling on or off and sets the longest
+// delay it may impose between two requests to a host
+func (hs *hostScheduler) configureThrottle(enabled bool, maxDelay time.Duration) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	hs.autoThrottle = enabled
+	hs.maxThrottle = maxDelay
+	for _, state := range hs.hosts {
+		if !enabled {
+			state.throttle = 0
+		} else if state.throttle > maxDelay {
+			state.throttle = maxDelay
+		}
+	}
+}
+
+// adapt adjusts a host's delay after a request. Failures (5xx and 429
+// responses, timeouts, connection errors) double the delay. Other responses
+// move it halfway towards the latency spread over the allowed connections,
+// so a slowing host gets fewer requests and a healthy one speeds back up to
+// the configured rate.
+func (hs *hostScheduler) adapt(host string, latency time.Duration, statusCode int, failed bool) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	if !hs.autoThrottle {
+		return
+	}
+
+	state := hs.host(host)
+	current := state.throttle
+	if current < hs.interval {
+		current = hs.interval
+	}
+
+	var next time.Duration
+	if failed || statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		next = 2 * current
+		if next < minThrottleDelay {
+			next = minThrottleDelay
+		}
+		if next < latency {
+			next = latency
+		}
+	} else {
+		connections := hs.maxConnections
+		if connections < 1 {
+			connections = 1
+		}
+		next = (current + latency/time.Duration(connections)) / 2
+	}
+
+	if next < hs.interval {
+		next = hs.interval
+	}
+	if next > hs.maxThrottle {
+		next = hs.maxThrottle
+	}
+	state.throttle = next
+}
+
+// delays returns the current spacing between requests to each of the hosts
+func (hs *hostScheduler) delays(hosts []string) []models.HostDelay {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	delays := make([]models.HostDelay, 0, len(hosts))
+	for _, host := range hosts {
+		delay := hs.interval
+		throttled := false
+		if state, ok := hs.hosts[strings.ToLower(host)]; ok {
+			delay = hs.spacing(state)
+			throttled = hs.autoThrottle && state.throttle > hs.interval && state.throttle >= delay
+		}
+		delays = append(delays, models.HostDelay{
+			Host:      host,
+			DelayMs:   delay.Milliseconds(),
+			Throttled: throttled,
+		})
+	}
+	return delays
+}
+
+// requestHosts records the hosts a crawl has sent requests to
+type requestHosts struct {
+	mutex sync.Mutex
+	hosts map[string]bool
+}
+
+func newRequestHosts() *requestHosts {
+	return &requestHosts{hosts: make(map[string]bool)}
+}
+
+func (h *requestHosts) add(host string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.hosts[strings.ToLower(host)] = true
+}
+
+// list returns the hosts in alphabetical order
+func (h *requestHosts) list() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	hosts := make([]string, 0, len(h.hosts))
+	for host := range h.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// hostDelays returns the per-host delays of a running crawl, or nil when the
+// crawl isn't running
+func (cs *CrawlerService) hostDelays(crawlResultID string) []models.HostDelay {
+	cs.mutex.RLock()
+	hosts, running := cs.crawlHosts[crawlResultID]
+	cs.mutex.RUnlock()
+	if !running {
+		return nil
+	}
+	return cs.hosts.delays(hosts.list())
+}
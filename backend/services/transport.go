@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"webcrawler/config"
+	"webcrawler/models"
+)
+
+// crawlerTransport is the HTTP transport shared by every crawl. It pools
+// connections across crawls, caches DNS lookups and counts how requests were
+// served.
+type crawlerTransport struct {
+	transport *http.Transport
+	dialer    *net.Dialer
+	dns       *dnsCache
+
+	requests    atomic.Int64
+	reusedConns atomic.Int64
+	newConns    atomic.Int64
+	dialErrors  atomic.Int64
+}
+
+// newCrawlerTransport builds the shared transport from the connection
+// settings of the config
+func newCrawlerTransport(cfg *config.Config) *crawlerTransport {
+	t := &crawlerTransport{
+		dialer: &net.Dialer{
+			Timeout:   time.Duration(cfg.DialTimeout) * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		dns: newDNSCache(time.Duration(cfg.DNSCacheTTL) * time.Second),
+	}
+	t.transport = &http.Transport{
+		Proxy:                 requestProxy,
+		DialContext:           t.dialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(cfg.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(cfg.TLSHandshakeTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout) * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return t
+}
+
+// proxyKey is the context key of a request's proxy URL
+type proxyKey struct{}
+
+// withProxy sends the requests of a context through a proxy. A nil proxy
+// leaves the choice to the proxy environment variables.
+func withProxy(ctx context.Context, proxy *url.URL) context.Context {
+	if proxy == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyKey{}, proxy)
+}
+
+// requestProxy returns the proxy of a request's crawl, falling back to the
+// proxy environment variables. Connections are pooled per proxy, so crawls
+// with different proxies can share the transport.
+func requestProxy(req *http.Request) (*url.URL, error) {
+	if proxy, ok := req.Context().Value(proxyKey{}).(*url.URL); ok {
+		return proxy, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+func (t *crawlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.reusedConns.Add(1)
+			}
+		},
+	}
+	return t.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// dialContext opens a connection using the DNS cache, trying the host's
+// addresses in turn
+func (t *crawlerTransport) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := t.dial(ctx, network, address)
+	if err != nil {
+		t.dialErrors.Add(1)
+		return nil, err
+	}
+	t.newConns.Add(1)
+	return conn, nil
+}
+
+func (t *crawlerTransport) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil || !t.dns.enabled() {
+		return t.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := t.dns.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	for _, addr := range addrs {
+		conn, err := t.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// stats returns the request counters of the transport
+func (t *crawlerTransport) stats() models.TransportStats {
+	stats := models.TransportStats{
+		Requests:          t.requests.Load(),
+		ReusedConnections: t.reusedConns.Load(),
+		NewConnections:    t.newConns.Load(),
+		DialErrors:        t.dialErrors.Load(),
+	}
+	stats.DNSCacheHits, stats.DNSCacheMisses, stats.DNSCacheEntries = t.dns.stats()
+	if connections := stats.ReusedConnections + stats.NewConnections; connections > 0 {
+		stats.ConnectionReuseRate = float64(stats.ReusedConnections) / float64(connections)
+	}
+	return stats
+}
+
+// TransportStats reports how the shared transport served requests
+func (cs *CrawlerService) TransportStats() models.TransportStats {
+	return cs.transport.stats()
+}
+
+// dnsEntry is a cached lookup result
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache remembers successful lookups for a fixed time. A TTL of 0
+// disables it.
+type dnsCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]dnsEntry
+	hits    int64
+	misses  int64
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{ttl: ttl, entries: make(map[string]dnsEntry)}
+}
+
+func (c *dnsCache) enabled() bool {
+	return c.ttl > 0
+}
+
+// lookup returns the addresses of a host, resolving it when it isn't cached
+// or the cached result has expired
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	now := time.Now()
+	c.mutex.Lock()
+	entry, ok := c.entries[host]
+	if ok && now.Before(entry.expires) {
+		c.hits++
+		c.mutex.Unlock()
+		return entry.addrs, nil
+	}
+	c.misses++
+	c.mutex.Unlock()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Drop expired entries so hosts that are no longer crawled don't pile up
+	for cached, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, cached)
+		}
+	}
+	c.entries[host] = dnsEntry{addrs: addrs, expires: now.Add(c.ttl)}
+	return addrs, nil
+}
+
+func (c *dnsCache) stats() (hits, misses int64, entries int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hits, c.misses, len(c.entries)
+}
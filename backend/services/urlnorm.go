@@ -0,0 +1,161 @@
+package services
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"webcrawler/models"
+)
+
+// trackingParams are query parameters that only identify a campaign or click
+// and never change the page that is served
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+	"igshid":  true,
+}
+
+// urlNormalizer canonicalizes URLs so that different spellings of the same
+// page are crawled and counted once
+type urlNormalizer struct {
+	sortQuery           bool
+	stripTracking       bool
+	ignoreTrailingSlash bool
+}
+
+// newURLNormalizer applies the crawl's normalization options over the
+// defaults, which enable every normalization
+func newURLNormalizer(options *models.NormalizationOptions) *urlNormalizer {
+	n := &urlNormalizer{
+		sortQuery:           true,
+		stripTracking:       true,
+		ignoreTrailingSlash: true,
+	}
+	if options != nil {
+		n.sortQuery = !options.PreserveQueryOrder
+		n.stripTracking = !options.PreserveTrackingParams
+		n.ignoreTrailingSlash = !options.PreserveTrailingSlash
+	}
+	return n
+}
+
+// Normalize returns the canonical form of an HTTP(S) URL: lowercase scheme
+// and host, no default port, no fragment, dot segments resolved and the
+// query cleaned up. Other URLs are returned unchanged.
+func (n *urlNormalizer) Normalize(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return raw
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 literal
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.Fragment = ""
+	u.RawFragment = ""
+	u = u.ResolveReference(&url.URL{})
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+
+	u.RawQuery = n.normalizeQuery(u.RawQuery)
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// Key returns the identity used to deduplicate URLs. It is the normalized
+// URL, without a trailing slash unless trailing slashes are significant.
+func (n *urlNormalizer) Key(raw string) string {
+	normalized := n.Normalize(raw)
+	if !n.ignoreTrailingSlash {
+		return normalized
+	}
+
+	u, err := url.Parse(normalized)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Path == "/" {
+		return normalized
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	return u.String()
+}
+
+// normalizeQuery drops tracking parameters and sorts the remaining ones
+// without re-encoding them
+func (n *urlNormalizer) normalizeQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var params []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		if n.stripTracking {
+			name, _, _ := strings.Cut(param, "=")
+			if decoded, err := url.QueryUnescape(name); err == nil {
+				name = decoded
+			}
+			name = strings.ToLower(name)
+			if strings.HasPrefix(name, "utm_") || trackingParams[name] {
+				continue
+			}
+		}
+		params = append(params, param)
+	}
+
+	if n.sortQuery {
+		sort.Strings(params)
+	}
+	return strings.Join(params, "&")
+}
+
+// normalizeLinks rewrites the page's links to their canonical form and
+// drops links that appear more than once on the page. The link references
+// keep the resolved hrefs, which links are checked and reported with.
+func (n *urlNormalizer) normalizeLinks(data *CrawlData) {
+	data.InternalLinks = n.dedupe(data.InternalLinks)
+	data.ExternalLinks = n.dedupe(data.ExternalLinks)
+}
+
+// dedupe normalizes links and keeps the first link for every key
+func (n *urlNormalizer) dedupe(links []string) []string {
+	seen := make(map[string]bool, len(links))
+	unique := make([]string, 0, len(links))
+	for _, link := range links {
+		key := n.Key(link)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, n.Normalize(link))
+	}
+	return unique
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"webcrawler/models"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *models.NormalizationOptions
+		raw     string
+		want    string
+	}{
+		{"lowercase scheme and host", nil, "HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"default http port", nil, "http://example.com:80/a", "http://example.com/a"},
+		{"default https port", nil, "https://example.com:443/a", "https://example.com/a"},
+		{"other port kept", nil, "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"ipv6 host", nil, "http://[::1]:80/a", "http://[::1]/a"},
+		{"fragment dropped", nil, "https://example.com/a#top", "https://example.com/a"},
+		{"empty path", nil, "https://example.com", "https://example.com/"},
+		{"dot segments", nil, "https://example.com/a/./b/../c", "https://example.com/a/c"},
+		{"dot segments above root", nil, "https://example.com/../../a", "https://example.com/a"},
+		{"trailing dot segment", nil, "https://example.com/a/b/..", "https://example.com/a/"},
+		{"query sorted", nil, "https://example.com/?b=2&a=1", "https://example.com/?a=1&b=2"},
+		{"query order preserved", &models.NormalizationOptions{PreserveQueryOrder: true},
+			"https://example.com/?b=2&a=1", "https://example.com/?b=2&a=1"},
+		{"query not re-encoded", nil, "https://example.com/?q=a%20b&p=x+y", "https://example.com/?p=x+y&q=a%20b"},
+		{"empty params dropped", nil, "https://example.com/?a=1&&b=2&", "https://example.com/?a=1&b=2"},
+		{"empty query dropped", nil, "https://example.com/a?", "https://example.com/a"},
+		{"utm params stripped", nil, "https://example.com/?utm_source=x&id=1&UTM_Medium=y", "https://example.com/?id=1"},
+		{"click ids stripped", nil, "https://example.com/?fbclid=1&gclid=2&msclkid=3&page=2", "https://example.com/?page=2"},
+		{"encoded tracking param stripped", nil, "https://example.com/?%75tm_source=x&id=1", "https://example.com/?id=1"},
+		{"only tracking params", nil, "https://example.com/a?utm_campaign=x", "https://example.com/a"},
+		{"tracking prefix lookalike kept", nil, "https://example.com/?utmost=1", "https://example.com/?utmost=1"},
+		{"tracking params preserved", &models.NormalizationOptions{PreserveTrackingParams: true},
+			"https://example.com/?utm_source=x&id=1", "https://example.com/?id=1&utm_source=x"},
+		{"trailing slash kept", nil, "https://example.com/a/", "https://example.com/a/"},
+		{"mailto unchanged", nil, "mailto:Someone@Example.com", "mailto:Someone@Example.com"},
+		{"relative unchanged", nil, "../page", "../page"},
+		{"whitespace trimmed", nil, "  https://example.com/a  ", "https://example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newURLNormalizer(tt.options).Normalize(tt.raw); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *models.NormalizationOptions
+		a, b    string
+		same    bool
+	}{
+		{"trailing slash ignored", nil, "https://example.com/about", "https://example.com/about/", true},
+		{"trailing slash significant", &models.NormalizationOptions{PreserveTrailingSlash: true},
+			"https://example.com/about", "https://example.com/about/", false},
+		{"root and empty path", nil, "https://example.com", "https://example.com/", true},
+		{"tracking params ignored", nil, "https://example.com/p?id=1", "https://example.com/p?utm_source=mail&id=1#x", true},
+		{"query order ignored", nil, "https://example.com/p?a=1&b=2", "https://example.com/p?b=2&a=1", true},
+		{"query order significant", &models.NormalizationOptions{PreserveQueryOrder: true},
+			"https://example.com/p?a=1&b=2", "https://example.com/p?b=2&a=1", false},
+		{"dot segments resolved", nil, "https://example.com/a/../b/", "https://example.com/b", true},
+		{"different query values", nil, "https://example.com/p?id=1", "https://example.com/p?id=2", false},
+		{"different schemes", nil, "http://example.com/", "https://example.com/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newURLNormalizer(tt.options)
+			keyA, keyB := n.Key(tt.a), n.Key(tt.b)
+			if (keyA == keyB) != tt.same {
+				t.Errorf("Key(%q) = %q, Key(%q) = %q, want same = %v", tt.a, keyA, tt.b, keyB, tt.same)
+			}
+		})
+	}
+}
+
+func TestNormalizeLinks(t *testing.T) {
+	data := &CrawlData{
+		InternalLinks: []string{
+			"https://example.com/a?utm_source=x",
+			"https://example.com/a",
+			"https://example.com/b/",
+			"https://example.com/b",
+		},
+		ExternalLinks: []string{"https://Other.example/x#top", "https://other.example/x"},
+		LinkRefs: []LinkRef{
+			{URL: "https://example.com/a?utm_source=x", Element: "a"},
+			{URL: "https://example.com/./b/", Element: "a"},
+		},
+	}
+	newURLNormalizer(nil).normalizeLinks(data)
+
+	wantInternal := []string{"https://example.com/a", "https://example.com/b/"}
+	if !reflect.DeepEqual(data.InternalLinks, wantInternal) {
+		t.Errorf("InternalLinks = %v, want %v", data.InternalLinks, wantInternal)
+	}
+	wantExternal := []string{"https://other.example/x"}
+	if !reflect.DeepEqual(data.ExternalLinks, wantExternal) {
+		t.Errorf("ExternalLinks = %v, want %v", data.ExternalLinks, wantExternal)
+	}
+	// Link references keep the href they were found with
+	wantRefs := []string{"https://example.com/a?utm_source=x", "https://example.com/./b/"}
+	for i, ref := range data.LinkRefs {
+		if ref.URL != wantRefs[i] {
+			t.Errorf("LinkRefs[%d].URL = %q, want %q", i, ref.URL, wantRefs[i])
+		}
+	}
+}
@@ -260,6 +260,16 @@ export function DetailModal({ result, isOpen, onClose }: DetailModalProps) {
                             Link text: "{link.text}"
                           </p>
                         )}
+                        {link.sourceUrl && (
+                          <p style={{ 
+                            fontSize: '0.75rem', 
+                            color: '#6b7280', 
+                            margin: '0.25rem 0 0 0',
+                            wordBreak: 'break-all'
+                          }}>
+                            Found in &lt;{link.element} {link.attribute}&gt; on {link.sourceUrl}
+                          </p>
+                        )}
                       </div>
                     </div>
                   ))}
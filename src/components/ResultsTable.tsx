@@ -328,7 +328,7 @@ export function ResultsTable({ data, onViewDetails }: ResultsTableProps) {
                       <Eye size={14} />
                     </button>
                     
-                    {result.status === 'queued' || result.status === 'error' ? (
+                    {result.status === 'queued' || result.status === 'error' || result.status === 'cancelled' ? (
                       <button
                         onClick={() => startCrawl.mutate(result.id)}
                         disabled={startCrawl.isPending}
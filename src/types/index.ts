@@ -2,6 +2,7 @@ export interface CrawlResult {
   id: string;
   url: string;
   title: string;
+  contentType?: string;
   htmlVersion: string;
   headingCounts: {
     h1: number;
@@ -13,10 +14,24 @@ export interface CrawlResult {
   };
   internalLinksCount: number;
   externalLinksCount: number;
+  specialLinkCounts?: {
+    mailto: number;
+    tel: number;
+    javascript: number;
+    fragment: number;
+    other: number;
+  };
   brokenLinksCount: number;
   hasLoginForm: boolean;
-  status: 'queued' | 'running' | 'completed' | 'error';
+  attempts?: number;
+  status: 'queued' | 'running' | 'completed' | 'error' | 'cancelled';
+  hostDelays?: {
+    host: string;
+    delayMs: number;
+    throttled: boolean;
+  }[];
   errorMessage?: string;
+  errorCategory?: string;
   crawledAt: string;
   brokenLinks: BrokenLink[];
 }
@@ -25,6 +40,10 @@ export interface BrokenLink {
   url: string;
   statusCode: number;
   text: string;
+  sourceUrl?: string;
+  element?: 'a' | 'img' | 'script' | 'link';
+  attribute?: string;
+  attempts?: number;
 }
 
 export interface CrawlRequest {